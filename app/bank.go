@@ -0,0 +1,301 @@
+package app
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DifficultyEasy   = "easy"
+	DifficultyMedium = "medium"
+	DifficultyHard   = "hard"
+)
+
+// Question is a question of the bank, which games ask in their tasks by reference or by drawing it.
+type Question struct {
+	ID            int
+	Question      string
+	Answers       pq.StringArray
+	Image         bool
+	CorrectAnswer string
+	TimeToAnswer  int
+	Multiplier    float64
+	AllowChange   bool
+	Category      string
+	Difficulty    string
+	Tags          pq.StringArray
+}
+
+func (q *Question) task() Task {
+	return Task{
+		QuestionID:    q.ID,
+		Question:      q.Question,
+		Answers:       q.Answers,
+		Image:         q.Image,
+		CorrectAnswer: q.CorrectAnswer,
+		TimeToAnswer:  q.TimeToAnswer,
+		Multiplier:    q.Multiplier,
+		AllowChange:   q.AllowChange,
+	}
+}
+
+// QuestionFilter matches the questions of the bank with any of the tags, if given, and with the
+// category and the difficulty, if given.
+type QuestionFilter struct {
+	Tags       []string `json:"tags,omitempty"`
+	Category   string   `json:"category,omitempty"`
+	Difficulty string   `json:"difficulty,omitempty"`
+}
+
+func (f *QuestionFilter) Matches(q *Question) bool {
+	if f.Category != "" && f.Category != q.Category {
+		return false
+	}
+	if f.Difficulty != "" && f.Difficulty != q.Difficulty {
+		return false
+	}
+	if len(f.Tags) == 0 {
+		return true
+	}
+	for _, tag := range f.Tags {
+		for _, t := range q.Tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *QuestionFilter) String() string {
+	parts := make([]string, 0, 3)
+	if len(f.Tags) > 0 {
+		parts = append(parts, "tags "+strings.Join(f.Tags, ", "))
+	}
+	if f.Category != "" {
+		parts = append(parts, "category "+f.Category)
+	}
+	if f.Difficulty != "" {
+		parts = append(parts, f.Difficulty)
+	}
+	if len(parts) == 0 {
+		return "any question"
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (f *QuestionFilter) Validate() error {
+	return validateDifficulty(f.Difficulty)
+}
+
+func validateDifficulty(difficulty string) error {
+	switch difficulty {
+	case "", DifficultyEasy, DifficultyMedium, DifficultyHard:
+		return nil
+	}
+	return fmt.Errorf("unknown difficulty %q", difficulty)
+}
+
+func (f *QuestionFilter) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, f)
+	case string:
+		return json.Unmarshal([]byte(src), f)
+	}
+	return fmt.Errorf("cannot scan %T into QuestionFilter", src)
+}
+
+func (f QuestionFilter) Value() (driver.Value, error) {
+	return json.Marshal(f)
+}
+
+// isPendingDraw reports whether the task draws a question that has not been drawn for the session.
+func (t *Task) isPendingDraw() bool {
+	return t.Draw != nil && t.QuestionID == 0
+}
+
+// drawTasks returns the tasks with a random question of the bank for each of the draws. The drawn
+// questions suit the type of the game and differ from each other and from the rest of the game;
+// draws that run out of questions are left out.
+func drawTasks(gameType string, tasks []*Task) []*Task {
+	used := make(map[int]bool)
+	for _, task := range tasks {
+		if task.Draw == nil && task.QuestionID != 0 {
+			used[task.QuestionID] = true
+		}
+	}
+
+	candidates := make(map[string][]*Question)
+	drawn := make([]*Task, 0, len(tasks))
+	for _, task := range tasks {
+		if task.Draw == nil {
+			drawn = append(drawn, task)
+			continue
+		}
+		key := task.Draw.String()
+		if _, ok := candidates[key]; !ok {
+			questions := GetQuestions(*task.Draw)
+			rand.Shuffle(len(questions), func(i, j int) {
+				questions[i], questions[j] = questions[j], questions[i]
+			})
+			candidates[key] = questions
+		}
+
+		var t *Task
+		for t == nil && len(candidates[key]) > 0 {
+			q := candidates[key][0]
+			candidates[key] = candidates[key][1:]
+			if qt := q.task(); !used[q.ID] && qt.Validate(gameType) == nil {
+				t = &qt
+			}
+		}
+		if t == nil {
+			log.Printf("error: task %d: no questions left to draw of %s", task.ID, task.Draw)
+			continue
+		}
+		used[t.QuestionID] = true
+		t.ID, t.Draw = task.ID, task.Draw
+		drawn = append(drawn, t)
+	}
+	return drawn
+}
+
+// withoutPendingDraws leaves out the tasks that have not drawn their questions.
+func withoutPendingDraws(tasks []*Task) []*Task {
+	drawn := make([]*Task, 0, len(tasks))
+	for _, task := range tasks {
+		if !task.isPendingDraw() {
+			drawn = append(drawn, task)
+		}
+	}
+	return drawn
+}
+
+// SessionTasks returns the tasks of the current session of the game, as drawn and ordered for it.
+// Self-paced games draw their questions the first time they are asked for in the session; live ones
+// draw them for every gameplay, see newGameplay, and save them when the game starts.
+func SessionTasks(game *Game) []*Task {
+	tasks := game.GetTasks()
+	if game.LastStartedAt == nil {
+		return withoutPendingDraws(tasks)
+	}
+	if !game.IsLive() && len(withoutPendingDraws(tasks)) < len(tasks) {
+		SaveDraws(game, *game.LastStartedAt, drawTasks(game.Type, tasks))
+		tasks = game.GetTasks()
+	}
+	return sessionOrder(game, *game.LastStartedAt, withoutPendingDraws(tasks))
+}
+
+// sessionTasksAt returns the tasks as drawn and ordered for the session of the game started at the
+// time.
+func sessionTasksAt(game *Game, startedAt time.Time) []*Task {
+	g := *game
+	g.LastStartedAt = &startedAt
+	return sessionOrder(game, startedAt, withoutPendingDraws(g.GetTasks()))
+}
+
+// checkDraws tells if the bank has too few questions for the draws of the tasks.
+func checkDraws(tasks []*Task) error {
+	filters := make(map[string]*QuestionFilter)
+	counts := make(map[string]int)
+	for _, task := range tasks {
+		if task.Draw != nil {
+			key := task.Draw.String()
+			filters[key] = task.Draw
+			counts[key]++
+		}
+	}
+	for key, count := range counts {
+		if available := len(GetQuestions(*filters[key])); available < count {
+			return fmt.Errorf("%d tasks draw %s, of which the bank has %d questions", count, key, available)
+		}
+	}
+	return nil
+}
+
+// ComposeGame stores a game of count tasks, each of which draws a random question matching the
+// filter every time the game is played.
+func ComposeGame(base Game, filter QuestionFilter, count int) (*Game, error) {
+	if count <= 0 {
+		return nil, errors.New("count must be positive")
+	}
+	f := &quizImport{game: newImportedGame(base)}
+	for i := 0; i < count; i++ {
+		task := newImportedTask()
+		task.Draw = &filter
+		f.add(task, fmt.Sprintf("task %d", i+1))
+	}
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	InsertGame(f.game, f.tasks)
+	return f.game, nil
+}
+
+// QuestionLabels are the category, the difficulty and the tags of an imported question.
+type QuestionLabels struct {
+	Category   string
+	Difficulty string
+	Tags       []string
+}
+
+// ImportQuestions reads the tasks of a file in the format, as ImportQuiz does, and adds them to the
+// bank. The questions must suit games of the type. Labels of the file, which are the category,
+// difficulty and tags columns of a CSV file, the categories of a GIFT file and the fields of the
+// tasks of a JSON file, take over those of defaults.
+func ImportQuestions(format string, r io.Reader, gameType string, defaults QuestionLabels) ([]*Question, error) {
+	f, err := parseQuiz(format, r, Game{Type: gameType})
+	if err != nil {
+		return nil, err
+	}
+	if len(f.tasks) == 0 && len(f.errs) == 0 {
+		return nil, errors.New("there are no questions")
+	}
+
+	questions := make([]*Question, len(f.tasks))
+	for i, task := range f.tasks {
+		if task.Draw != nil || task.QuestionID != 0 {
+			f.fail(f.where[i], errors.New("the bank cannot refer to itself"))
+		}
+		labels := f.labels[i]
+		if labels.Category == "" {
+			labels.Category = defaults.Category
+		}
+		if labels.Difficulty == "" {
+			labels.Difficulty = defaults.Difficulty
+		}
+		if len(labels.Tags) == 0 {
+			labels.Tags = defaults.Tags
+		}
+		if err = validateDifficulty(labels.Difficulty); err != nil {
+			f.fail(f.where[i], err)
+		}
+		questions[i] = &Question{
+			Question:      task.Question,
+			Answers:       task.Answers,
+			Image:         task.Image,
+			CorrectAnswer: task.CorrectAnswer,
+			TimeToAnswer:  task.TimeToAnswer,
+			Multiplier:    task.Multiplier,
+			AllowChange:   task.AllowChange,
+			Category:      labels.Category,
+			Difficulty:    labels.Difficulty,
+			Tags:          labels.Tags,
+		}
+	}
+	if len(f.errs) > 0 {
+		return nil, f.errs
+	}
+	InsertQuestions(questions...)
+	return questions, nil
+}
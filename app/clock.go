@@ -0,0 +1,175 @@
+package app
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time to the games: when tasks start and end, when answers were given and when
+// the server pings the players. A FakeClock lets tests fast-forward through tasks and simulations
+// replay a game.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f in its own goroutine once d has elapsed.
+	AfterFunc(d time.Duration, f func())
+	NewTicker(d time.Duration) Ticker
+	Sleep(d time.Duration)
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// DefaultClock is the clock of new pools, the wall clock unless replaced.
+var DefaultClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+type fakeTimer struct {
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+	f      func()
+}
+
+// FakeClock only moves when told to. Timers and tickers fire as Advance passes their time, in the
+// order they are due; like real tickers, fake ones drop ticks nobody has received.
+type FakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+	mu     sync.Mutex
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) add(timer *fakeTimer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.timers = append(c.timers, timer)
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	timer := &fakeTimer{at: c.Now().Add(d), c: make(chan time.Time, 1)}
+	c.add(timer)
+	return timer.c
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) {
+	c.add(&fakeTimer{at: c.Now().Add(d), f: f})
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	timer := &fakeTimer{at: c.Now().Add(d), period: d, c: make(chan time.Time, 1)}
+	c.add(timer)
+	return &fakeTicker{clock: c, timer: timer}
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Advance moves the clock forward by d, firing the timers due on the way.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	until := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool {
+			return c.timers[i].at.Before(c.timers[j].at)
+		})
+		if len(c.timers) == 0 || c.timers[0].at.After(until) {
+			break
+		}
+		timer := c.timers[0]
+		c.now = timer.at
+		if timer.period > 0 {
+			timer.at = timer.at.Add(timer.period)
+		} else {
+			c.timers = c.timers[1:]
+		}
+		if timer.f != nil {
+			go timer.f()
+		} else {
+			select {
+			case timer.c <- c.now:
+			default:
+			}
+		}
+	}
+	c.now = until
+}
+
+// Waiting counts the timers and tickers that have not fired or stopped yet.
+func (c *FakeClock) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	timer *fakeTimer
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.timer.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, timer := range t.clock.timers {
+		if timer == t.timer {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return
+		}
+	}
+}
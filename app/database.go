@@ -2,7 +2,10 @@ package app
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"time"
 
 	"github.com/elgris/sqrl"
@@ -13,6 +16,9 @@ import (
 var DB *sql.DB
 var QB sqrl.StatementBuilderType
 
+// sqlStore keeps the games and scores in PostgreSQL, see kakadoo.sql.
+type sqlStore struct{}
+
 func init() {
 	var err error
 
@@ -30,40 +36,178 @@ const (
 	GameTypeFindCat = "find_cat"
 )
 
+const (
+	GameModeLive      = "live"
+	GameModeSelfPaced = "self_paced"
+)
+
 type Task struct {
-	ID            int
+	ID int
+	// QuestionID is the question of the bank the task asks, if any, either by reference or as drawn
+	// for the session when Draw is set.
+	QuestionID    int
+	Draw          *QuestionFilter
 	Question      string
 	Answers       pq.StringArray
+	Image         bool
 	CorrectAnswer string
 	TimeToAnswer  int
+	Multiplier    float64
+	AllowChange   bool
+}
+
+func (t *Task) multiplier() float64 {
+	if t.Multiplier <= 0 {
+		return 1
+	}
+	return t.Multiplier
 }
 
 func (t *Task) timeToAnswer() time.Duration {
 	return time.Duration(t.TimeToAnswer) * time.Second
 }
 
+// isImage reports whether the task is answered by clicking on the image in the question,
+// in which case CorrectAnswer holds the target regions. All find_cat tasks are, and quiz tasks
+// that are marked so.
+func (t *Task) isImage(gameType string) bool {
+	return gameType == GameTypeFindCat || (gameType == GameTypeQuiz && t.Image)
+}
+
+// isValidAnswer reports whether the answer is one the task could accept, right or wrong.
+func (t *Task) isValidAnswer(gameType string, answer string) bool {
+	if t.isImage(gameType) {
+		_, err := ParsePoint(answer)
+		return err == nil
+	}
+	switch gameType {
+	case GameTypeQuiz:
+		for _, a := range t.Answers {
+			if a == answer {
+				return true
+			}
+		}
+		return false
+	case GameTypeWoC:
+		_, err := parseEstimate(answer)
+		return err == nil
+	}
+	return true
+}
+
+func (t *Task) Validate(gameType string) error {
+	if t.isPendingDraw() {
+		return t.Draw.Validate()
+	}
+	if t.Question == "" {
+		return errors.New("question is empty")
+	}
+	if gameType != GameTypeFindCat && t.TimeToAnswer <= 0 {
+		return errors.New("time to answer must be positive")
+	}
+	if math.IsNaN(t.Multiplier) || math.IsInf(t.Multiplier, 0) {
+		return errors.New("multiplier must be a finite number")
+	}
+	if t.Multiplier <= 0 {
+		return errors.New("multiplier must be positive")
+	}
+	if t.isImage(gameType) {
+		if len(t.Answers) > 0 {
+			return errors.New("image tasks have no answers to choose from")
+		}
+		if _, err := ParseRegions(t.CorrectAnswer); err != nil {
+			return fmt.Errorf("correct answer: %v", err)
+		}
+		return nil
+	}
+	if t.Image {
+		return fmt.Errorf("%s tasks cannot be image tasks", gameType)
+	}
+
+	switch gameType {
+	case GameTypeQuiz:
+		if len(t.Answers) == 0 {
+			return errors.New("there are no answers to choose from")
+		}
+		for _, answer := range t.Answers {
+			if answer == t.CorrectAnswer {
+				return nil
+			}
+		}
+		return fmt.Errorf("correct answer %q is not one of the answers", t.CorrectAnswer)
+	case GameTypeWoC:
+		if _, err := parseEstimate(t.CorrectAnswer); err != nil {
+			return fmt.Errorf("correct answer %q is not a number", t.CorrectAnswer)
+		}
+	}
+	return nil
+}
+
 type Game struct {
 	ID            int
 	Type          string
+	Mode          string
 	Title         string
 	Author        string
 	IsStarted     bool
 	LastStartedAt *time.Time
+	OpensAt       *time.Time
+	ClosesAt      *time.Time
+	ScheduledAt   *time.Time
+	TaskGap       int
+	Options       GameOptions
+}
+
+// IsLive reports whether the game is driven by its author over the wire. Unless the mode is set
+// explicitly, find_cat games are self-paced and the rest are live.
+func (g *Game) IsLive() bool {
+	if g.Mode == "" {
+		return g.Type != GameTypeFindCat
+	}
+	return g.Mode == GameModeLive
+}
+
+func (g *Game) taskGap() time.Duration {
+	return time.Duration(g.TaskGap) * time.Second
 }
 
-func (g *Game) GetTasks() []*Task {
-	q := QB.Select("id", "question", "answers", "correct_answer", "time_to_answer").
-		From("tasks").Where("game_id = ?", g.ID).OrderBy("id")
+var gameColumns = []string{"id", "type", "COALESCE(mode::varchar, '')", "title", "author", "last_started_at",
+	"opens_at", "closes_at", "scheduled_at", "task_gap", "options"}
+
+func scanGame(scanner interface{ Scan(...interface{}) error }, game *Game) error {
+	return scanner.Scan(&game.ID, &game.Type, &game.Mode, &game.Title, &game.Author, &game.LastStartedAt,
+		&game.OpensAt, &game.ClosesAt, &game.ScheduledAt, &game.TaskGap, &game.Options)
+}
+
+func (*sqlStore) GetTasks(g *Game) []*Task {
+	// questions of the bank are asked as they are now, and drawn ones as drawn for the session
+	q := QB.Select("t.id", "COALESCE(q.id, 0)", "t.draw", "COALESCE(q.question, t.question)",
+		"COALESCE(q.answers, t.answers)", "COALESCE(q.image, t.image)", "COALESCE(q.correct_answer, t.correct_answer)",
+		"COALESCE(q.time_to_answer, t.time_to_answer)", "COALESCE(q.multiplier, t.multiplier)",
+		"COALESCE(q.allow_change, t.allow_change)").
+		From("tasks t").
+		LeftJoin("draws d ON d.task_id = t.id AND d.session = ?", utcPtr(g.LastStartedAt)).
+		LeftJoin("questions q ON q.id = COALESCE(d.question_id, t.question_id)").
+		Where("t.game_id = ?", g.ID).OrderBy("t.id")
 	rows, err := q.Query()
 	defer func() { _ = rows.Close() }()
 
 	tasks := make([]*Task, 0)
 	for rows.Next() {
 		task := &Task{}
-		err = rows.Scan(&task.ID, &task.Question, &task.Answers, &task.CorrectAnswer, &task.TimeToAnswer)
+		var draw []byte
+		err = rows.Scan(&task.ID, &task.QuestionID, &draw, &task.Question, &task.Answers, &task.Image,
+			&task.CorrectAnswer,
+			&task.TimeToAnswer, &task.Multiplier, &task.AllowChange)
 		if err != nil {
 			panic(err)
 		}
+		if draw != nil {
+			task.Draw = &QuestionFilter{}
+			if err = task.Draw.Scan(draw); err != nil {
+				panic(err)
+			}
+		}
 		tasks = append(tasks, task)
 	}
 	if err = rows.Err(); err != nil {
@@ -72,16 +216,14 @@ func (g *Game) GetTasks() []*Task {
 	return tasks
 }
 
-func GetGames() []*Game {
-	q := QB.Select("id", "type", "title", "author").From("games").OrderBy("created_at")
+func queryGames(q *sqrl.SelectBuilder) []*Game {
 	rows, err := q.Query()
 	defer func() { _ = rows.Close() }()
 
 	games := make([]*Game, 0)
 	for rows.Next() {
 		game := &Game{}
-		err = rows.Scan(&game.ID, &game.Type, &game.Title, &game.Author)
-		if err != nil {
+		if err = scanGame(rows, game); err != nil {
 			panic(err)
 		}
 		games = append(games, game)
@@ -92,15 +234,21 @@ func GetGames() []*Game {
 	return games
 }
 
-func GetGameByHash(hash string) *Game {
-	id := GameHashID.Decode(hash)
-	if id == -1 {
-		return nil
-	}
+func (*sqlStore) GetGames() []*Game {
+	return queryGames(QB.Select(gameColumns...).From("games").OrderBy("created_at"))
+}
+
+func (*sqlStore) GetScheduledGames(from, to time.Time) []*Game {
+	return queryGames(QB.Select(gameColumns...).From("games").
+		Where("scheduled_at BETWEEN ? AND ?", utc(from), utc(to)).
+		Where("last_started_at IS NULL OR last_started_at < scheduled_at").
+		OrderBy("scheduled_at"))
+}
 
-	game := &Game{ID: id}
-	q := QB.Select("type", "title", "author", "last_started_at").From("games").Where("id = ?", id)
-	if err := q.Scan(&game.Type, &game.Title, &game.Author, &game.LastStartedAt); err != nil {
+func (*sqlStore) GetGame(id int) *Game {
+	game := &Game{}
+	q := QB.Select(gameColumns...).From("games").Where("id = ?", id)
+	if err := scanGame(q, game); err != nil {
 		if err == sql.ErrNoRows {
 			return nil
 		}
@@ -109,14 +257,74 @@ func GetGameByHash(hash string) *Game {
 	return game
 }
 
-func UpdateGameStartedAt(game *Game, startedAt time.Time) {
-	q := QB.Update("games").Set("last_started_at", startedAt).Where("id = ?", game.ID)
+func (*sqlStore) InsertGame(game *Game, tasks []*Task) {
+	tx, err := DB.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			panic(err)
+		}
+	}()
+
+	var mode interface{}
+	if game.Mode != "" {
+		mode = game.Mode
+	}
+	qb := QB.RunWith(tx)
+	err = qb.Insert("games").
+		Columns("type", "mode", "title", "author", "opens_at", "closes_at", "scheduled_at", "task_gap", "options").
+		Values(game.Type, mode, game.Title, game.Author, utcPtr(game.OpensAt), utcPtr(game.ClosesAt),
+			utcPtr(game.ScheduledAt), game.TaskGap, game.Options).
+		Suffix("RETURNING id").QueryRow().Scan(&game.ID)
+	if err != nil {
+		return
+	}
+	for _, task := range tasks {
+		var questionID, draw interface{}
+		if task.Draw != nil {
+			draw = *task.Draw
+		} else if task.QuestionID != 0 {
+			questionID = task.QuestionID
+		}
+		err = qb.Insert("tasks").
+			Columns("game_id", "question_id", "draw", "question", "answers", "image", "correct_answer",
+				"time_to_answer", "multiplier", "allow_change").
+			Values(game.ID, questionID, draw, task.Question, task.Answers, task.Image, task.CorrectAnswer,
+				task.TimeToAnswer, task.Multiplier, task.AllowChange).
+			Suffix("RETURNING id").QueryRow().Scan(&task.ID)
+		if err != nil {
+			return
+		}
+	}
+	err = tx.Commit()
+}
+
+// utc converts a time to UTC before it is stored: the timestamp columns keep no time zone, so only
+// times in UTC are read back as the same instants whatever the time zone of the server.
+func utc(t time.Time) time.Time {
+	return t.UTC()
+}
+
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+func (*sqlStore) UpdateGameStartedAt(game *Game, startedAt time.Time) {
+	q := QB.Update("games").Set("last_started_at", utc(startedAt)).Where("id = ?", game.ID)
 	if _, err := q.Exec(); err != nil {
 		panic(err)
 	}
 }
 
 type Score struct {
+	ID        int
 	Game      *Game
 	Task      *Task
 	Player    string
@@ -127,7 +335,7 @@ type Score struct {
 	CreatedAt time.Time
 }
 
-func InsertScores(scores ...*Score) {
+func (*sqlStore) InsertScores(scores ...*Score) {
 	qi := QB.Insert("scores").
 		Columns("game_id", "task_id", "player", "player_key", "question", "answer", "score", "created_at")
 	any := false
@@ -141,7 +349,7 @@ func InsertScores(scores ...*Score) {
 		}
 		if !exists {
 			qi = qi.Values(sc.Game.ID, sc.Task.ID, sc.Player, sc.PlayerKey, sc.Question, sc.Answer, sc.Score,
-				sc.CreatedAt)
+				utc(sc.CreatedAt))
 			any = true
 		}
 	}
@@ -152,14 +360,81 @@ func InsertScores(scores ...*Score) {
 	}
 }
 
-type _dbScore struct {
-	Player    string  `json:"player"`
-	Score     float64 `json:"score"`
-	Completed int     `json:"completed"`
+func (*sqlStore) GetTaskScores(game *Game, task *Task) []*Score {
+	q := QB.Select("s.id", "s.player", "COALESCE(s.player_key, '')", "s.answer", "s.score", "s.created_at").
+		From("scores s").Join("games g ON s.game_id = g.id").
+		Where("s.game_id = ? AND s.task_id = ? AND s.created_at >= g.last_started_at", game.ID, task.ID).
+		OrderBy("s.id")
+	rows, err := q.Query()
+	defer func() { _ = rows.Close() }()
+
+	scores := make([]*Score, 0)
+	for rows.Next() {
+		score := &Score{Game: game, Task: task, Question: task.Question}
+		err = rows.Scan(&score.ID, &score.Player, &score.PlayerKey, &score.Answer, &score.Score, &score.CreatedAt)
+		if err != nil {
+			panic(err)
+		}
+		scores = append(scores, score)
+	}
+	if err = rows.Err(); err != nil {
+		panic(err)
+	}
+	return scores
+}
+
+func (*sqlStore) UpdateScore(score *Score) {
+	q := QB.Update("scores").Set("score", score.Score).Where("id = ?", score.ID)
+	if _, err := q.Exec(); err != nil {
+		panic(err)
+	}
+}
+
+func (*sqlStore) DeliverTask(game *Game, task *Task, player string, playerKey string, now time.Time) time.Time {
+	var deliveredAt time.Time
+	qs := QB.Select("d.delivered_at").From("deliveries d").Join("games g ON d.game_id = g.id").
+		Where("d.game_id = ? AND d.task_id = ? AND d.player = ? AND d.player_key = ?",
+			game.ID, task.ID, player, playerKey).
+		Where("d.delivered_at >= g.last_started_at")
+	err := qs.Scan(&deliveredAt)
+	if err == nil {
+		return deliveredAt
+	}
+	if err != sql.ErrNoRows {
+		panic(err)
+	}
+
+	qi := QB.Insert("deliveries").Columns("game_id", "task_id", "player", "player_key", "delivered_at").
+		Values(game.ID, task.ID, player, playerKey, utc(now))
+	if _, err = qi.Exec(); err != nil {
+		panic(err)
+	}
+	return now
+}
+
+func (*sqlStore) GetDeliveries(game *Game, task *Task) []Delivery {
+	q := QB.Select("d.player", "d.player_key", "d.delivered_at").From("deliveries d").
+		Join("games g ON d.game_id = g.id").
+		Where("d.game_id = ? AND d.task_id = ? AND d.delivered_at >= g.last_started_at", game.ID, task.ID)
+	rows, err := q.Query()
+	defer func() { _ = rows.Close() }()
+
+	deliveries := make([]Delivery, 0)
+	for rows.Next() {
+		var d Delivery
+		if err = rows.Scan(&d.Player, &d.PlayerKey, &d.DeliveredAt); err != nil {
+			panic(err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		panic(err)
+	}
+	return deliveries
 }
 
-func GetScores(game *Game) []_dbScore {
-	scores := make([]_dbScore, 0)
+func (*sqlStore) GetScores(game *Game) []TotalScore {
+	scores := make([]TotalScore, 0)
 	q := QB.Select("s.player", "SUM(s.score)",
 		"COUNT(s.id) * 100 / (SELECT COUNT(t.*) FROM tasks t WHERE t.game_id = g.id) completed").
 		From("scores s").Join("games g ON s.game_id = g.id").
@@ -168,7 +443,7 @@ func GetScores(game *Game) []_dbScore {
 	rows, err := q.Query()
 	defer func() { _ = rows.Close() }()
 	for rows.Next() {
-		score := _dbScore{}
+		score := TotalScore{}
 		err = rows.Scan(&score.Player, &score.Score, &score.Completed)
 		if err != nil {
 			panic(err)
@@ -178,7 +453,31 @@ func GetScores(game *Game) []_dbScore {
 	return scores
 }
 
-func HasPlayerInScores(game *Game, player string, playerKey string) bool {
+func (*sqlStore) GetPlayerStreak(game *Game, player string, playerKey string) int {
+	q := QB.Select("s.score").From("scores s").Join("games g ON s.game_id = g.id").
+		Where("s.game_id = ? AND s.player = ? AND s.player_key = ?", game.ID, player, playerKey).
+		Where("s.created_at >= g.last_started_at").OrderBy("s.id DESC")
+	rows, err := q.Query()
+	defer func() { _ = rows.Close() }()
+
+	streak := 0
+	for rows.Next() {
+		var score float64
+		if err = rows.Scan(&score); err != nil {
+			panic(err)
+		}
+		if score <= 0 {
+			break
+		}
+		streak++
+	}
+	if err = rows.Err(); err != nil {
+		panic(err)
+	}
+	return streak
+}
+
+func (*sqlStore) HasPlayerInScores(game *Game, player string, playerKey string) bool {
 	var exists bool
 	q := QB.Select("s.id").Prefix("SELECT EXISTS(").From("scores s").Join("games g ON s.game_id = g.id").
 		Where("s.game_id = ? AND s.player = ? AND s.player_key <> ?", game.ID, player, playerKey).
@@ -188,3 +487,150 @@ func HasPlayerInScores(game *Game, player string, playerKey string) bool {
 	}
 	return exists
 }
+
+func (*sqlStore) AppendEvents(events ...*Event) {
+	if len(events) == 0 {
+		return
+	}
+	q := QB.Insert("events").Columns("game_id", "session", "seq", "type", "player", "data", "created_at")
+	for _, e := range events {
+		var data interface{}
+		if e.Data != nil {
+			data = string(e.Data)
+		}
+		q = q.Values(e.GameID, utc(e.Session), e.Seq, e.Type, e.Player, data, utc(e.CreatedAt))
+	}
+	if _, err := q.Exec(); err != nil {
+		panic(err)
+	}
+}
+
+func (*sqlStore) GetSessions(game *Game) []time.Time {
+	q := QB.Select("session").From("events").Where("game_id = ?", game.ID).
+		GroupBy("session").OrderBy("session DESC")
+	rows, err := q.Query()
+	defer func() { _ = rows.Close() }()
+
+	sessions := make([]time.Time, 0)
+	for rows.Next() {
+		var session time.Time
+		if err = rows.Scan(&session); err != nil {
+			panic(err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		panic(err)
+	}
+	return sessions
+}
+
+func (*sqlStore) GetEvents(game *Game, session time.Time) []*Event {
+	q := QB.Select("seq", "type", "player", "COALESCE(data::text, '')", "created_at").From("events").
+		Where("game_id = ? AND session = ?", game.ID, utc(session)).OrderBy("seq")
+	rows, err := q.Query()
+	defer func() { _ = rows.Close() }()
+
+	events := make([]*Event, 0)
+	for rows.Next() {
+		event := &Event{GameID: game.ID, Session: session}
+		var data string
+		if err = rows.Scan(&event.Seq, &event.Type, &event.Player, &data, &event.CreatedAt); err != nil {
+			panic(err)
+		}
+		if data != "" {
+			event.Data = []byte(data)
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		panic(err)
+	}
+	return events
+}
+
+func (*sqlStore) InsertQuestions(questions ...*Question) {
+	for _, question := range questions {
+		var difficulty interface{}
+		if question.Difficulty != "" {
+			difficulty = question.Difficulty
+		}
+		tags := question.Tags
+		if tags == nil {
+			tags = pq.StringArray{}
+		}
+		q := QB.Insert("questions").
+			Columns("question", "answers", "image", "correct_answer", "time_to_answer", "multiplier", "allow_change",
+				"category", "difficulty", "tags").
+			Values(question.Question, question.Answers, question.Image, question.CorrectAnswer,
+				question.TimeToAnswer, question.Multiplier, question.AllowChange, question.Category, difficulty, tags).
+			Suffix("RETURNING id")
+		if err := q.QueryRow().Scan(&question.ID); err != nil {
+			panic(err)
+		}
+	}
+}
+
+var questionColumns = []string{"id", "question", "answers", "image", "correct_answer", "time_to_answer",
+	"multiplier", "allow_change", "category", "COALESCE(difficulty::varchar, '')", "tags"}
+
+func scanQuestion(scanner interface{ Scan(...interface{}) error }, q *Question) error {
+	return scanner.Scan(&q.ID, &q.Question, &q.Answers, &q.Image, &q.CorrectAnswer, &q.TimeToAnswer,
+		&q.Multiplier, &q.AllowChange, &q.Category, &q.Difficulty, &q.Tags)
+}
+
+func (*sqlStore) GetQuestion(id int) *Question {
+	question := &Question{}
+	q := QB.Select(questionColumns...).From("questions").Where("id = ?", id)
+	if err := scanQuestion(q, question); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		panic(err)
+	}
+	return question
+}
+
+func (*sqlStore) GetQuestions(filter QuestionFilter) []*Question {
+	q := QB.Select(questionColumns...).From("questions").OrderBy("id")
+	if len(filter.Tags) > 0 {
+		q = q.Where("tags && ?", pq.StringArray(filter.Tags))
+	}
+	if filter.Category != "" {
+		q = q.Where("category = ?", filter.Category)
+	}
+	if filter.Difficulty != "" {
+		q = q.Where("difficulty = ?", filter.Difficulty)
+	}
+	rows, err := q.Query()
+	defer func() { _ = rows.Close() }()
+
+	questions := make([]*Question, 0)
+	for rows.Next() {
+		question := &Question{}
+		if err = scanQuestion(rows, question); err != nil {
+			panic(err)
+		}
+		questions = append(questions, question)
+	}
+	if err = rows.Err(); err != nil {
+		panic(err)
+	}
+	return questions
+}
+
+func (*sqlStore) SaveDraws(game *Game, session time.Time, tasks []*Task) {
+	q := QB.Insert("draws").Columns("task_id", "session", "question_id")
+	any := false
+	for _, task := range tasks {
+		if task.Draw != nil && task.QuestionID != 0 {
+			q = q.Values(task.ID, utc(session), task.QuestionID)
+			any = true
+		}
+	}
+	if any {
+		if _, err := q.Suffix("ON CONFLICT DO NOTHING").Exec(); err != nil {
+			panic(err)
+		}
+	}
+}
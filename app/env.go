@@ -7,6 +7,8 @@ import (
 var (
 	SecretKey   = GetEnv("SECRET_KEY", "")
 	DatabaseURL = GetEnv("DATABASE_URL", "postgres://localhost/kakadoo?sslmode=disable")
+	// AdminPassword, if set, protects the pages that change stored scores with basic authentication.
+	AdminPassword = GetEnv("ADMIN_PASSWORD", "")
 )
 
 func GetEnv(key, defaultValue string) string {
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lokhman/kakadoo/protocol"
+)
+
+const eventLogBatch = 1024
+
+// Event is an entry of the append-only log of a live session. Type and Data are those of the wire
+// message, Player is who sent the message or whom it concerns, if anyone.
+type Event struct {
+	GameID    int                  `json:"-"`
+	Session   time.Time            `json:"-"`
+	Seq       int                  `json:"seq"`
+	Type      protocol.MessageType `json:"type"`
+	Player    string               `json:"player,omitempty"`
+	Data      json.RawMessage      `json:"data,omitempty"`
+	CreatedAt time.Time            `json:"time"`
+}
+
+// eventLog records the joins, the tasks, the answers and the results of a session, leaving out the
+// timer and the answer progress, which follow from the rest. The events are written to the store in
+// batches by a goroutine of their own. Recording never waits for the store, as it is done from
+// Pool.Run and with the gameplay locked: the events pile up in pending until they are written.
+type eventLog struct {
+	gameID  int
+	session time.Time
+	seq     int
+	pending []*Event
+	wake    chan struct{}
+	closed  bool
+	mu      sync.Mutex
+}
+
+func newEventLog(gameID int, session time.Time) *eventLog {
+	l := &eventLog{
+		gameID:  gameID,
+		session: session,
+		wake:    make(chan struct{}, 1),
+	}
+	go l.write()
+	return l
+}
+
+func (l *eventLog) write() {
+	for {
+		_, ok := <-l.wake
+
+		l.mu.Lock()
+		events := l.pending
+		l.pending = nil
+		l.mu.Unlock()
+
+		if len(events) > eventLogBatch {
+			log.Printf("event log of game %d is behind by %d events", l.gameID, len(events))
+		}
+		for len(events) > 0 {
+			n := len(events)
+			if n > eventLogBatch {
+				n = eventLogBatch
+			}
+			l.append(events[:n])
+			events = events[n:]
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+func (l *eventLog) append(events []*Event) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("error: event log of game %d: %v", l.gameID, err)
+		}
+	}()
+
+	AppendEvents(events...)
+}
+
+// record logs the message at the given time. Gameplays replayed from a log have no log of their own.
+func (l *eventLog) record(t protocol.MessageType, player string, data interface{}, at time.Time) {
+	if l == nil {
+		return
+	}
+	var raw json.RawMessage
+	if data != nil {
+		var err error
+		if raw, err = json.Marshal(data); err != nil {
+			panic(err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+	l.seq++
+	l.pending = append(l.pending, &Event{
+		GameID:    l.gameID,
+		Session:   l.session,
+		Seq:       l.seq,
+		Type:      t,
+		Player:    player,
+		Data:      raw,
+		CreatedAt: at,
+	})
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (l *eventLog) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.closed {
+		l.closed = true
+		close(l.wake)
+	}
+}
+
+// ReplayTasks returns the tasks of the game as drawn for the session of the events.
+func ReplayTasks(game *Game, events []*Event) []*Task {
+	for _, event := range events {
+		if event.Type == protocol.GameStarted {
+			return sessionTasksAt(game, event.CreatedAt)
+		}
+	}
+	return withoutPendingDraws(game.GetTasks())
+}
+
+// ReplayScores plays the session of the events again with the current tasks and scoring rules of the
+// game. It returns the final leaderboard as recorded, if the game was finished, and as recomputed,
+// so that scores can be checked after fixing a task or the scoring.
+func ReplayScores(game *Game, events []*Event) ([]lbScore, []lbScore, error) {
+	gp := newGameplayWithTasks(game, ReplayTasks(game, events), DefaultClock)
+	gp.initBaselines()
+	players := make(map[string]*Player)
+
+	var recorded []lbScore
+	for _, event := range events {
+		var err error
+		switch event.Type {
+		case protocol.PlayerRegistered:
+			var data protocol.Player
+			if err = json.Unmarshal(event.Data, &data); err == nil {
+				player := &Player{Game: game, Name: data.Name, Team: data.Team, IsAuthor: data.IsAuthor}
+				players[player.Name] = player
+				gp.Init(player)
+			}
+		case protocol.PlayerUnregistered:
+			if player, ok := players[event.Player]; ok {
+				gp.Leave(player)
+				delete(players, event.Player)
+			}
+		case protocol.PowerUp:
+			var powerUp string
+			if err = json.Unmarshal(event.Data, &powerUp); err == nil && players[event.Player] != nil {
+				gp.armed[players[event.Player]] = powerUp
+			}
+		case protocol.Task:
+			var data protocol.TaskData
+			if err = json.Unmarshal(event.Data, &data); err == nil {
+				if data.Index >= len(gp.tasks) {
+					return nil, nil, fmt.Errorf("event %d: the game has no task %d", event.Seq, data.Index+1)
+				}
+				gp.currentTaskIndex = data.Index
+				gp.answers[data.Index] = make(map[*Player]gpAnswer)
+				gp.deadline = event.CreatedAt.Add(gp.tasks[data.Index].timeToAnswer())
+			}
+		case protocol.AnswerAccepted:
+			var data protocol.AnswerAcceptedData
+			if err = json.Unmarshal(event.Data, &data); err == nil && players[event.Player] != nil &&
+				data.Index < len(gp.answers) && gp.answers[data.Index] != nil {
+				gp.answers[data.Index][players[event.Player]] = gpAnswer{answer: data.Answer, time: event.CreatedAt}
+			}
+		case protocol.TaskFinished:
+			gp.calculateScores(gp.tasks[gp.currentTaskIndex])
+		case protocol.GameFinished:
+			var data protocol.GameFinishedData
+			if err = json.Unmarshal(event.Data, &data); err == nil {
+				recorded = data.Scores
+			}
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("event %d: %v", event.Seq, err)
+		}
+	}
+	return recorded, gp.scores.Leaderboard(), nil
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lokhman/kakadoo/protocol"
+)
+
+// stalledStore holds the events back until it is released, as a slow database would.
+type stalledStore struct {
+	*MemoryStore
+	release chan struct{}
+}
+
+func (s *stalledStore) AppendEvents(events ...*Event) {
+	<-s.release
+	s.MemoryStore.AppendEvents(events...)
+}
+
+func TestEventLogRecordDoesNotWaitForStore(t *testing.T) {
+	store := &stalledStore{NewMemoryStore(), make(chan struct{})}
+	defer func(s Store) { DefaultStore = s }(DefaultStore)
+	DefaultStore = store
+
+	session := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newEventLog(1, session)
+	count := 3 * eventLogBatch
+
+	recorded := make(chan struct{})
+	go func() {
+		for i := 0; i < count; i++ {
+			l.record(protocol.Answer, "player", i, session)
+		}
+		l.close()
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("record waits for the store")
+	}
+
+	close(store.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events := store.GetEvents(&Game{ID: 1}, session)
+		if len(events) == count {
+			for i, event := range events {
+				if event.Seq != i+1 {
+					t.Fatalf("event %d has seq %d", i, event.Seq)
+				}
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d events, want %d", len(events), count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sheet is a table of results to export. Cells are strings, ints, floats or times; a nil cell is
+// left empty. The first row is the header.
+type Sheet struct {
+	Name string
+	Rows [][]interface{}
+}
+
+func (s *Sheet) append(cells ...interface{}) {
+	s.Rows = append(s.Rows, cells)
+}
+
+func formatCell(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// csvCell formats the cell for a CSV file. Text that spreadsheets would take for a formula, such as
+// an answer of "=HYPERLINK(...)", is quoted with an apostrophe; numbers, even given as text like an
+// estimate of "-5", are left as they are.
+func csvCell(cell interface{}) string {
+	s := formatCell(cell)
+	if _, ok := cell.(string); ok && s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return "'" + s
+		}
+	}
+	return s
+}
+
+func (s *Sheet) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	for _, row := range s.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = csvCell(cell)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// SessionResults returns the summary of the current session of the game, a row per player as on the
+// scoreboard, and the detail of it, a row per answer.
+func SessionResults(game *Game) (*Sheet, *Sheet) {
+	summary := &Sheet{Name: "Summary"}
+	summary.append("Place", "Player", "Score", "Completed (%)")
+	for i, score := range GetScores(game) {
+		summary.append(i+1, score.Player, score.Score, score.Completed)
+	}
+
+	answers := &Sheet{Name: "Answers"}
+	answers.append("Player", "Task", "Question", "Answer", "Correct", "Score", "Response time (s)", "Answered at")
+	if game.LastStartedAt == nil {
+		return summary, answers
+	}
+	var starts map[int]time.Time
+	if game.IsLive() {
+		// sessions from before the event log have no response times
+		starts, _, _ = sessionTimeline(game)
+	}
+	for i, task := range SessionTasks(game) {
+		scores := GetTaskScores(game, task)
+		elapsed, err := answerTimes(game, task, i, scores, starts)
+		for j, sc := range scores {
+			var responseTime interface{}
+			if err == nil && (game.IsLive() || game.Type != GameTypeFindCat) {
+				responseTime = float64(elapsed[j].Milliseconds()) / 1000
+			}
+			answers.append(sc.Player, i+1, task.Question, sc.Answer, answerCorrectness(game.Type, task, sc.Answer),
+				sc.Score, responseTime, sc.CreatedAt)
+		}
+	}
+	return summary, answers
+}
+
+// answerCorrectness tells whether a quiz answer is correct, or how much of the regions of an image
+// it hits. Estimates are not correct or wrong, but scored by rank.
+func answerCorrectness(gameType string, task *Task, answer string) interface{} {
+	switch {
+	case gameType == GameTypeWoC:
+		return nil
+	case task.isImage(gameType):
+		regions, err := ParseRegions(task.CorrectAnswer)
+		if err != nil {
+			return nil
+		}
+		return regions.ScoreAnswer(answer)
+	case answer == task.CorrectAnswer:
+		return "yes"
+	default:
+		return "no"
+	}
+}
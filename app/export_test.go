@@ -0,0 +1,171 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// exportGame stores a played self-paced quiz of a task, answered right by alice and wrong by bob.
+func exportGame(t *testing.T) *Game {
+	store := NewMemoryStore()
+	prev := DefaultStore
+	DefaultStore = store
+	t.Cleanup(func() { DefaultStore = prev })
+
+	game := store.AddGame(&Game{Type: GameTypeQuiz, Mode: GameModeSelfPaced, Title: "Quiz", Author: "host"},
+		&Task{Question: "2 + 2", Answers: []string{"3", "4"}, CorrectAnswer: "4", TimeToAnswer: 10})
+	startedAt := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	UpdateGameStartedAt(game, startedAt)
+	game = store.GetGame(game.ID)
+	task := game.GetTasks()[0]
+
+	for _, answer := range []struct {
+		player string
+		answer string
+		score  float64
+		after  time.Duration
+	}{
+		{"alice", "4", 1000, 2500 * time.Millisecond},
+		{"bob", "=1+1", 0, time.Second},
+	} {
+		DeliverTask(game, task, answer.player, "", startedAt.Add(time.Second))
+		InsertScores(&Score{Game: game, Task: task, Player: answer.player, Answer: answer.answer,
+			Score: answer.score, CreatedAt: startedAt.Add(time.Second + answer.after)})
+	}
+	return game
+}
+
+func TestSessionResults(t *testing.T) {
+	summary, answers := SessionResults(exportGame(t))
+
+	var b bytes.Buffer
+	if err := summary.WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Place,Player,Score,Completed (%)\n1,alice,1000,100\n2,bob,0,100\n"; b.String() != want {
+		t.Errorf("got summary\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := answers.WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Player", "Task", "Question", "Answer", "Correct", "Score", "Response time (s)", "Answered at"},
+		{"alice", "1", "2 + 2", "4", "yes", "1000", "2.5", "2021-06-01 12:00:03"},
+		{"bob", "1", "2 + 2", "'=1+1", "no", "0", "1", "2021-06-01 12:00:02"},
+	}
+	if fmt.Sprint(records) != fmt.Sprint(want) {
+		t.Errorf("got answers %q, want %q", records, want)
+	}
+}
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		cell interface{}
+		want string
+	}{
+		{"Paris", "Paris"},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+1", "+1"},
+		{"-5", "-5"},
+		{"-2.5e3", "-2.5e3"},
+		{"-1+1", "'-1+1"},
+		{"-cmd|' /C calc'!A0", "'-cmd|' /C calc'!A0"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"", ""},
+		{-1, "-1"},
+		{-2.5, "-2.5"},
+		{-250.0, "-250"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := csvCell(tt.cell); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.cell, got, tt.want)
+		}
+	}
+}
+
+type xlsxTestSheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R    string `xml:"r,attr"`
+			S    string `xml:"s,attr"`
+			T    string `xml:"t,attr"`
+			V    string `xml:"v"`
+			Text string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func TestWriteXLSX(t *testing.T) {
+	summary, answers := SessionResults(exportGame(t))
+	answers.Name = "Answers: [all]"
+
+	var b bytes.Buffer
+	if err := WriteXLSX(&b, summary, answers); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string][]byte)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parts[f.Name], err = ioutil.ReadAll(r); err != nil {
+			t.Fatal(err)
+		}
+		_ = r.Close()
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml",
+		"xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml", "xl/worksheets/sheet2.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Fatalf("no part %s", name)
+		}
+		if err = xml.Unmarshal(parts[name], new(interface{})); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if !bytes.Contains(parts["xl/workbook.xml"], []byte(`<sheet name="Answers all" sheetId="2" r:id="rId2"/>`)) {
+		t.Errorf("got workbook %s", parts["xl/workbook.xml"])
+	}
+
+	var sheet xlsxTestSheet
+	if err = xml.Unmarshal(parts["xl/worksheets/sheet2.xml"], &sheet); err != nil {
+		t.Fatal(err)
+	}
+	if len(sheet.Rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(sheet.Rows))
+	}
+	header := sheet.Rows[0].Cells[0]
+	if header.R != "A1" || header.S != "1" || header.T != "inlineStr" || header.Text != "Player" {
+		t.Errorf("got header cell %+v", header)
+	}
+	bob := sheet.Rows[2].Cells
+	if len(bob) != 8 {
+		t.Fatalf("got %d cells, want 8", len(bob))
+	}
+	// text stays text, as inline strings are never evaluated
+	if bob[3].R != "D3" || bob[3].T != "inlineStr" || bob[3].Text != "=1+1" {
+		t.Errorf("got answer cell %+v", bob[3])
+	}
+	if bob[5].R != "F3" || bob[5].T != "" || bob[5].V != "0" || bob[6].V != "1" {
+		t.Errorf("got number cells %+v %+v", bob[5], bob[6])
+	}
+}
@@ -2,31 +2,23 @@ package app
 
 import (
 	"fmt"
+	"log"
 	"net/http"
-	"strconv"
-	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-type findCatForm struct {
-	Player      string `form:"p"`
-	Key         string `form:"k"`
-	Index       int    `form:"w"`
-	Answer      string `form:"v"`
-	ErrorPlayer bool   `form:"ep"`
-}
-
 func FindCat(c *gin.Context) {
 	game := c.MustGet("game").(*Game)
-	tasks := game.GetTasks()
+	tasks := SessionTasks(game)
 
-	var form findCatForm
+	var form selfPacedForm
 	_ = c.ShouldBind(&form)
 
 	var task *Task
 	var index = 0
+	var score float64
+	var shapes []*Region
 	if form.Player != "" {
 		player := StripHtmlTags(form.Player)
 		if form.Key == "" {
@@ -45,8 +37,13 @@ func FindCat(c *gin.Context) {
 			return
 		}
 		if game.LastStartedAt == nil {
-			UpdateGameStartedAt(game, time.Now())
+			startedAt := DefaultClock.Now()
+			UpdateGameStartedAt(game, startedAt)
+			// the order of the tasks follows the start as stored, which every later request reads
+			game = GetGame(game.ID)
+			tasks = SessionTasks(game)
 		}
+		tasks = playerOrder(game, tasks, player, form.Key)
 
 		if index = form.Index; index < 0 {
 			index = 0
@@ -59,27 +56,15 @@ func FindCat(c *gin.Context) {
 		if form.Answer != "" {
 			form.Index++
 
-			var score float64
-			answer := strings.Split(form.Answer, ",")
-			correctAnswer := strings.Split(task.CorrectAnswer, ",")
-			if len(answer) == 2 && len(correctAnswer) == 4 {
-				var x, y, x1, y1, x2, y2 int
-				var err error
-				if x, err = strconv.Atoi(answer[0]); err != nil {
-					goto score
-				}
-				if y, err = strconv.Atoi(answer[1]); err != nil {
-					goto score
-				}
-				x1, _ = strconv.Atoi(correctAnswer[0])
-				y1, _ = strconv.Atoi(correctAnswer[1])
-				x2, _ = strconv.Atoi(correctAnswer[2])
-				y2, _ = strconv.Atoi(correctAnswer[3])
-				if x >= x1 && x <= x2 && y >= y1 && y <= y2 {
-					score = 1
-				}
+			regions, err := ParseRegions(task.CorrectAnswer)
+			if err != nil {
+				log.Printf("error: task %d: %v", task.ID, err)
+				c.HTML(http.StatusOK, "find_cat", gin.H{"form": form, "error": true})
+				return
 			}
-		score:
+			score = regions.ScoreAnswer(form.Answer)
+			shapes = regions.Regions
+
 			InsertScores(&Score{
 				Game:      game,
 				Task:      task,
@@ -88,7 +73,7 @@ func FindCat(c *gin.Context) {
 				Question:  task.Question,
 				Answer:    form.Answer,
 				Score:     score,
-				CreatedAt: time.Now(),
+				CreatedAt: DefaultClock.Now(),
 			})
 		}
 	}
@@ -96,6 +81,8 @@ func FindCat(c *gin.Context) {
 	c.HTML(http.StatusOK, "find_cat", gin.H{
 		"form":    form,
 		"task":    task,
+		"score":   score,
+		"regions": shapes,
 		"counter": fmt.Sprintf("%d / %d", index+1, len(tasks)),
 	})
 }
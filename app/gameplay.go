@@ -1,12 +1,13 @@
 package app
 
 import (
-	"fmt"
-	"math"
+	"errors"
+	"log"
 	"sort"
-	"strconv"
 	"sync"
 	"time"
+
+	"github.com/lokhman/kakadoo/protocol"
 )
 
 type gpState int
@@ -18,13 +19,6 @@ const (
 	gpsFinished
 )
 
-const (
-	wocPlayerMean   = "Mean"
-	wocPlayerMedian = "Median"
-)
-
-const correctAnswerBaseScore = 15_000
-
 type gameplay struct {
 	currentTaskIndex int
 	gameType         string
@@ -33,9 +27,37 @@ type gameplay struct {
 	scores           gpScores
 	state            gpState
 	deadline         time.Time
+	startsAt         *time.Time
+	startedAt        time.Time
+	teams            []string
+	teamScoring      string
+	scoring          scoringStrategy
+	streaks          map[*Player]int
+	powerUps         []string
+	armed            map[*Player]string
+	usedPowerUps     map[*Player]map[string]bool
+	woc              WoCOptions
+	maxPlayers       int
+	lateJoin         string
+	ready            map[*Player]bool
+	pin              string
+	autoEnd          bool
+	allAnswered      chan struct{}
+	progressPending  bool
+	clock            Clock
+	events           *eventLog
 	mu               sync.Mutex
 }
 
+// initBaselines adds the pseudo-players of the crowd baselines to woc games.
+func (gp *gameplay) initBaselines() {
+	if gp.gameType == GameTypeWoC {
+		for _, baseline := range gp.woc.baselines() {
+			gp.Init(&Player{Name: wocBaselinePlayers[baseline], IsBaseline: true})
+		}
+	}
+}
+
 func (gp *gameplay) Init(player *Player) {
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
@@ -43,6 +65,160 @@ func (gp *gameplay) Init(player *Player) {
 	gp.scores[player] = make([]float64, len(gp.tasks))
 }
 
+// Leave removes the player from the gameplay. With auto-end on, the current task ends if every
+// player left has answered it.
+func (gp *gameplay) Leave(player *Player) {
+	var allAnswered chan struct{}
+	defer func() {
+		if allAnswered != nil {
+			close(allAnswered)
+		}
+	}()
+
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	delete(gp.scores, player)
+	delete(gp.ready, player)
+	delete(gp.streaks, player)
+	delete(gp.armed, player)
+	allAnswered = gp.takeAllAnswered()
+}
+
+// NumPlayers counts the players of the gameplay, not including the crowd baselines.
+func (gp *gameplay) NumPlayers() int {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	n := 0
+	for player := range gp.scores {
+		if !player.IsBaseline {
+			n++
+		}
+	}
+	return n
+}
+
+// SetReady marks the player as ready or not for the game to start.
+func (gp *gameplay) SetReady(player *Player, ready bool) bool {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	if _, ok := gp.scores[player]; !ok || gp.state != gpsReady {
+		return false
+	}
+	if ready {
+		gp.ready[player] = true
+	} else {
+		delete(gp.ready, player)
+	}
+	return true
+}
+
+func (gp *gameplay) GetReadyPlayers() []string {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	players := make([]string, 0, len(gp.ready))
+	for player := range gp.ready {
+		players = append(players, player.Name)
+	}
+	return players
+}
+
+// AssignTeam returns the requested team if the game has one with this name, otherwise the team
+// with the fewest players. Games without teams always get an empty team.
+func (gp *gameplay) AssignTeam(team string) string {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	if len(gp.teams) == 0 {
+		return ""
+	}
+	sizes := make(map[string]int, len(gp.teams))
+	for _, name := range gp.teams {
+		if name == team {
+			return team
+		}
+		sizes[name] = 0
+	}
+	for player := range gp.scores {
+		if _, ok := sizes[player.Team]; ok {
+			sizes[player.Team]++
+		}
+	}
+	team = gp.teams[0]
+	for _, name := range gp.teams[1:] {
+		if sizes[name] < sizes[team] {
+			team = name
+		}
+	}
+	return team
+}
+
+// TeamLeaderboard must be called with the gameplay locked, e.g. from the callback of NextTask.
+func (gp *gameplay) TeamLeaderboard() []lbTeamScore {
+	if len(gp.teams) == 0 {
+		return nil
+	}
+	return gp.scores.TeamLeaderboard(gp.teams, gp.teamScoring)
+}
+
+// ArmPowerUp arms the power-up for the next task of the player. Every power-up can be armed once
+// per game and only between tasks.
+func (gp *gameplay) ArmPowerUp(player *Player, powerUp string) bool {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	if _, ok := gp.scores[player]; !ok || (gp.state != gpsReady && gp.state != gpsStarted) {
+		return false
+	}
+	if _, ok := gp.armed[player]; ok || gp.usedPowerUps[player][powerUp] {
+		return false
+	}
+	for _, name := range gp.powerUps {
+		if name == powerUp {
+			if gp.usedPowerUps[player] == nil {
+				gp.usedPowerUps[player] = make(map[string]bool)
+			}
+			gp.usedPowerUps[player][powerUp] = true
+			gp.armed[player] = powerUp
+			return true
+		}
+	}
+	return false
+}
+
+// Streaks returns the numbers of correct answers in a row by the names of the players who have any.
+func (gp *gameplay) Streaks() map[string]int {
+	streaks := make(map[string]int)
+	for player, streak := range gp.streaks {
+		if streak > 0 {
+			streaks[player.Name] = streak
+		}
+	}
+	return streaks
+}
+
+// WoCDistribution summarises the estimates given for the current task, or returns nil if the correct
+// answer of the task is not a number.
+func (gp *gameplay) WoCDistribution(task *Task) *wocDistribution {
+	correctAnswer, err := parseEstimate(task.CorrectAnswer)
+	if err != nil {
+		return nil
+	}
+	answers := gp.answers[gp.currentTaskIndex]
+	players := make([]string, 0, len(answers))
+	estimates := make([]float64, 0, len(answers))
+	for player, answer := range answers {
+		if estimate, err := parseEstimate(answer.answer); err == nil {
+			players = append(players, player.Name)
+			estimates = append(estimates, estimate)
+		}
+	}
+	return newWoCDistribution(gp.woc, correctAnswer, players, estimates)
+}
+
 func (gp *gameplay) GetPlayers() []*Player {
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
@@ -57,16 +233,67 @@ func (gp *gameplay) GetPlayers() []*Player {
 	return players
 }
 
-func (gp *gameplay) Start() int {
+func (gp *gameplay) State() gpState {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	return gp.state
+}
+
+func (gp *gameplay) NumTasks() int {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	return len(gp.tasks)
+}
+
+// Schedule hands the gameplay over to the scheduler, which starts it at the given time.
+func (gp *gameplay) Schedule(startsAt time.Time) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	gp.startsAt = &startsAt
+}
+
+func (gp *gameplay) IsScheduled() bool {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	return gp.startsAt != nil
+}
+
+func (gp *gameplay) StartsAt() *time.Time {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	return gp.startsAt
+}
+
+// Deadline returns the time the current task stops accepting answers.
+func (gp *gameplay) Deadline() time.Time {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	return gp.deadline
+}
+
+// Start puts the tasks in the order of the session of the game started at the time.
+func (gp *gameplay) Start(game *Game, startedAt time.Time) int {
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
 
 	gp.state = gpsStarted
+	gp.startedAt = startedAt
+	gp.tasks = sessionOrder(game, startedAt, gp.tasks)
 	gp.currentTaskIndex = 0
 	return len(gp.tasks)
 }
 
-func (gp *gameplay) NextTask(tick func(int), callback func(gp *gameplay, task *Task)) *Task {
+// NextTask starts the next task and returns it, or nil if there is none. Every second of the task
+// is ticked with the seconds left. Once the task is over and scored, the callback is called with the
+// gameplay locked; the function it returns, if any, is called after the gameplay is unlocked, so
+// that the results can be sent out without holding up the players.
+func (gp *gameplay) NextTask(tick func(int), callback func(gp *gameplay, task *Task) func()) *Task {
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
 
@@ -81,150 +308,202 @@ func (gp *gameplay) NextTask(tick func(int), callback func(gp *gameplay, task *T
 	task := gp.tasks[gp.currentTaskIndex]
 
 	gp.answers[gp.currentTaskIndex] = make(map[*Player]gpAnswer)
-	gp.deadline = time.Now().Add(task.timeToAnswer())
+	gp.deadline = gp.clock.Now().Add(task.timeToAnswer())
 	gp.state = gpsAccepting
+	gp.allAnswered = make(chan struct{})
 
+	// the ticker starts with the task, so that a fake clock can be advanced as soon as it is sent
+	ticker := gp.clock.NewTicker(time.Second)
+	allAnswered := gp.allAnswered
 	go func() {
+	countdown:
 		for timer := task.TimeToAnswer - 1; timer >= 0; timer-- {
-			time.Sleep(time.Second)
-			tick(timer)
+			select {
+			case <-ticker.C():
+				tick(timer)
+			case <-allAnswered:
+				break countdown
+			}
 		}
+		ticker.Stop()
 
 		gp.mu.Lock()
-		defer gp.mu.Unlock()
-
-		gp.calculateScores(task)
-
-		callback(gp, task)
-
+		InsertScores(gp.calculateScores(task)...)
+		finished := callback(gp, task)
 		gp.state = gpsStarted
 		gp.currentTaskIndex++
+		gp.mu.Unlock()
+
+		if finished != nil {
+			finished()
+		}
 	}()
 
 	return task
 }
 
-func (gp *gameplay) Answer(player *Player, answer string) {
+var (
+	errAnswerNotPlaying = errors.New(protocol.RejectNotPlaying)
+	errAnswerLate       = errors.New(protocol.RejectLate)
+	errAnswerDuplicate  = errors.New(protocol.RejectDuplicate)
+	errAnswerInvalid    = errors.New(protocol.RejectInvalid)
+)
+
+// Answer records the answer of the player to the current task and returns the index of the task
+// with the recorded answer. Only the first answer is recorded, unless the task allows to change it
+// until the deadline. With auto-end on, the task ends as soon as every player has answered.
+func (gp *gameplay) Answer(player *Player, answer string) (int, gpAnswer, error) {
+	var allAnswered chan struct{}
+	defer func() {
+		if allAnswered != nil {
+			close(allAnswered)
+		}
+	}()
+
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
 
+	if _, ok := gp.scores[player]; !ok {
+		return 0, gpAnswer{}, errAnswerNotPlaying
+	}
 	if gp.state != gpsAccepting {
-		return
+		return gp.currentTaskIndex, gpAnswer{}, errAnswerLate
 	}
+	task := gp.tasks[gp.currentTaskIndex]
 	answers := gp.answers[gp.currentTaskIndex]
-	if _, ok := answers[player]; !ok {
-		answers[player] = gpAnswer{
-			answer: answer,
-			time:   time.Now(),
-		}
+	if _, ok := answers[player]; ok && !task.AllowChange {
+		return gp.currentTaskIndex, answers[player], errAnswerDuplicate
+	}
+	if !task.isValidAnswer(gp.gameType, answer) {
+		return gp.currentTaskIndex, gpAnswer{}, errAnswerInvalid
 	}
+	answers[player] = gpAnswer{
+		answer: answer,
+		time:   gp.clock.Now(),
+	}
+	allAnswered = gp.takeAllAnswered()
+	return gp.currentTaskIndex, answers[player], nil
 }
 
-func (gp *gameplay) calculateScores(task *Task) {
-	answers := gp.answers[gp.currentTaskIndex]
-	if len(answers) == 0 {
-		return
+// takeAllAnswered returns the channel that ends the current task if auto-end is on and every player
+// has answered, for the caller to close once the gameplay is unlocked, or nil otherwise.
+func (gp *gameplay) takeAllAnswered() chan struct{} {
+	if !gp.autoEnd || gp.allAnswered == nil || gp.state != gpsAccepting {
+		return nil
 	}
+	if answered, total := gp.progress(); answered < total {
+		return nil
+	}
+	allAnswered := gp.allAnswered
+	gp.allAnswered = nil
+	return allAnswered
+}
 
-	if gp.gameType == GameTypeQuiz {
-		for player, answer := range answers {
-			scores := gp.scores[player]
-			if answer.answer == task.CorrectAnswer {
-				baseScore := correctAnswerBaseScore * (1 / math.Max(float64(task.TimeToAnswer), 1))
-				scores[gp.currentTaskIndex] = baseScore + float64(gp.deadline.Sub(answer.time).Milliseconds())
-			}
+// progress counts the players who answered the current task out of all players expected to
+// answer, which are all but the crowd baselines and the author driving the game.
+func (gp *gameplay) progress() (int, int) {
+	answered, total := 0, 0
+	answers := gp.answers[gp.currentTaskIndex]
+	for player := range gp.scores {
+		if player.IsBaseline {
+			continue
 		}
-	} else if gp.gameType == GameTypeWoC {
-		correctAnswer, err := strconv.ParseFloat(task.CorrectAnswer, 64)
-		if err != nil {
-			panic(err)
+		_, ok := answers[player]
+		if ok {
+			answered++
+		}
+		if ok || !player.IsAuthor {
+			total++
 		}
+	}
+	return answered, total
+}
 
-		meanValue := 0.0
-		medianValue := 0.0
+// ThrottleProgress reports whether the caller should send the answer progress after a while,
+// which is the case if it is not already pending.
+func (gp *gameplay) ThrottleProgress() bool {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
 
-		type wocScore struct {
-			player *Player
-			value  float64
-		}
-		scores := make([]*wocScore, 0)
+	if gp.progressPending {
+		return false
+	}
+	gp.progressPending = true
+	return true
+}
 
-		for player, answer := range answers {
-			if answer, err := strconv.ParseFloat(answer.answer, 64); err == nil {
-				scores = append(scores, &wocScore{
-					player: player,
-					value:  answer,
-				})
-				meanValue += answer
-			}
-		}
+// Progress returns the index of the current task with its answer progress and clears the pending
+// state of ThrottleProgress.
+func (gp *gameplay) Progress() (int, int, int) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
 
-		meanValue /= float64(len(scores))
-		sort.Slice(scores, func(i, j int) bool {
-			return scores[i].value < scores[j].value
-		})
+	gp.progressPending = false
+	answered, total := gp.progress()
+	return gp.currentTaskIndex, answered, total
+}
 
-		index := len(scores) / 2
-		if len(scores)%2 == 0 {
-			medianValue = (scores[index-1].value + scores[index].value) / 2
-		} else {
-			medianValue = scores[index].value
+// calculateScores scores the answers to the current task and returns the scores to persist. The
+// answers of players who left before the task ended are dropped with the rest of their scores.
+func (gp *gameplay) calculateScores(task *Task) []*Score {
+	answers := make(map[*Player]gpAnswer, len(gp.answers[gp.currentTaskIndex]))
+	for player, answer := range gp.answers[gp.currentTaskIndex] {
+		if gp.scores[player] != nil {
+			answers[player] = answer
 		}
+	}
 
-		var meanPlayer, medianPlayer *Player
-		for player := range gp.scores {
-			if player.Name == wocPlayerMean {
-				meanPlayer = player
-			} else if player.Name == wocPlayerMedian {
-				medianPlayer = player
+	if gp.gameType == GameTypeQuiz || gp.gameType == GameTypeFindCat {
+		// players who armed a power-up are scored even if they did not answer
+		for player := range gp.armed {
+			if _, ok := answers[player]; !ok && gp.scores[player] != nil {
+				answers[player] = gpAnswer{time: gp.deadline}
 			}
 		}
-
-		scores = append(scores, &wocScore{
-			value:  meanValue,
-			player: meanPlayer,
-		}, &wocScore{
-			value:  medianValue,
-			player: medianPlayer,
-		})
-
-		for _, score := range scores {
-			value := score.value
-			score.value = math.Abs(value - correctAnswer)
+		for player, answer := range answers {
+			remaining := gp.deadline.Sub(answer.time)
+			score := scoreAnswer(gp.scoring, gp.gameType, task, answer.answer, remaining, gp.streaks[player])
+			if gp.armed[player] == PowerUpDoubleOrNothing {
+				score = doubleOrNothing(gp.scoring, task, score)
+			}
+			gp.scores[player][gp.currentTaskIndex] = score
 		}
-
-		sort.Slice(scores, func(i, j int) bool {
-			return scores[i].value > scores[j].value
-		})
-
-		type wocGroup struct {
-			indexes []int
-			players []*Player
+		for player, scores := range gp.scores {
+			if scores[gp.currentTaskIndex] > 0 {
+				gp.streaks[player]++
+			} else {
+				delete(gp.streaks, player)
+			}
 		}
-		groups := make(map[string]*wocGroup)
-		for index, score := range scores {
-			value := fmt.Sprintf("%.2f", score.value)
-			if _, ok := groups[value]; !ok {
-				groups[value] = &wocGroup{
-					indexes: make([]int, 0),
-					players: make([]*Player, 0),
-				}
+	} else if gp.gameType == GameTypeWoC && len(answers) > 0 {
+		players := make([]*Player, 0, len(answers))
+		estimates := make([]float64, 0, len(answers))
+		for player, answer := range answers {
+			if estimate, err := parseEstimate(answer.answer); err == nil {
+				players = append(players, player)
+				estimates = append(estimates, estimate)
 			}
-			groups[value].indexes = append(groups[value].indexes, index)
-			groups[value].players = append(groups[value].players, score.player)
 		}
 
-		for _, group := range groups {
-			for _, player := range group.players {
-				score := 0.0
-				for _, index = range group.indexes {
-					score += float64(index)
+		correctAnswer, err := parseEstimate(task.CorrectAnswer)
+		scores, baselines := []float64(nil), map[string]float64(nil)
+		if err == nil {
+			scores, baselines, err = scoreWoC(gp.woc, correctAnswer, estimates)
+		}
+		if err != nil {
+			log.Printf("error: task %d: %v", task.ID, err)
+		} else {
+			for i, player := range players {
+				gp.scores[player][gp.currentTaskIndex] = task.multiplier() * scores[i]
+			}
+			for player := range gp.scores {
+				if score, ok := baselines[player.Name]; ok && player.IsBaseline {
+					gp.scores[player][gp.currentTaskIndex] = task.multiplier() * score
 				}
-				score /= float64(len(group.indexes))
-				gp.scores[player][gp.currentTaskIndex] = score
 			}
 		}
 	}
+	gp.armed = make(map[*Player]string)
 
 	i := 0
 	scores := make([]*Score, len(answers))
@@ -240,25 +519,44 @@ func (gp *gameplay) calculateScores(task *Task) {
 		}
 		i++
 	}
-	InsertScores(scores...)
+	return scores
 }
 
-func (gp *gameplay) Finish() gpScores {
+// Finish returns the final leaderboards of the players and of the teams, taken while the gameplay is
+// locked, as players may still be joining.
+func (gp *gameplay) Finish() ([]lbScore, []lbTeamScore) {
 	gp.mu.Lock()
 	defer gp.mu.Unlock()
 
 	gp.state = gpsFinished
-	return gp.scores
+	return gp.scores.Leaderboard(), gp.TeamLeaderboard()
+}
+
+// newGameplay draws the questions of the tasks that draw them from the bank, anew for every gameplay.
+func newGameplay(game *Game, clock Clock) *gameplay {
+	return newGameplayWithTasks(game, drawTasks(game.Type, game.GetTasks()), clock)
 }
 
-func newGameplay(game *Game) *gameplay {
-	tasks := game.GetTasks()
+func newGameplayWithTasks(game *Game, tasks []*Task, clock Clock) *gameplay {
 	return &gameplay{
-		gameType: game.Type,
-		tasks:    tasks,
-		answers:  make(gpAnswers, len(tasks)),
-		scores:   make(gpScores),
-		state:    gpsReady,
+		gameType:     game.Type,
+		tasks:        tasks,
+		answers:      make(gpAnswers, len(tasks)),
+		scores:       make(gpScores),
+		state:        gpsReady,
+		teams:        game.Options.Teams,
+		teamScoring:  game.Options.TeamScoring,
+		scoring:      newScoringStrategy(game.Options.Scoring),
+		streaks:      make(map[*Player]int),
+		powerUps:     game.Options.PowerUps,
+		armed:        make(map[*Player]string),
+		usedPowerUps: make(map[*Player]map[string]bool),
+		woc:          game.Options.WoC,
+		maxPlayers:   game.Options.MaxPlayers,
+		lateJoin:     game.Options.LateJoin,
+		ready:        make(map[*Player]bool),
+		autoEnd:      game.Options.AutoEnd,
+		clock:        clock,
 	}
 }
 
@@ -271,19 +569,22 @@ type gpAnswers []map[*Player]gpAnswer
 
 type gpScores map[*Player][]float64
 
+func (s gpScores) total(player *Player) float64 {
+	total := 0.0
+	for _, score := range s[player] {
+		total += score
+	}
+	return total
+}
+
 func (s gpScores) Leaderboard() []lbScore {
 	board := make([]lbScore, len(s))
 	index := 0
-	for player, scores := range s {
-		total := 0.0
-		for _, score := range scores {
-			if score > 0 {
-				total += score
-			}
-		}
+	for player := range s {
 		board[index] = lbScore{
 			Player: player.Name,
-			Score:  total,
+			Team:   player.Team,
+			Score:  s.total(player),
 		}
 		index++
 	}
@@ -293,7 +594,42 @@ func (s gpScores) Leaderboard() []lbScore {
 	return board
 }
 
-type lbScore struct {
-	Player string  `json:"player"`
-	Score  float64 `json:"score"`
+func (s gpScores) TeamLeaderboard(teams []string, scoring string) []lbTeamScore {
+	board := make([]lbTeamScore, len(teams))
+	index := make(map[string]*lbTeamScore, len(teams))
+	for i, team := range teams {
+		board[i] = lbTeamScore{Team: team}
+		index[team] = &board[i]
+	}
+	for player := range s {
+		team, ok := index[player.Team]
+		if !ok {
+			continue
+		}
+		total := s.total(player)
+		switch scoring {
+		case TeamScoringBest:
+			if team.Players == 0 || total > team.Score {
+				team.Score = total
+			}
+		default:
+			team.Score += total
+		}
+		team.Players++
+	}
+	if scoring == TeamScoringAverage {
+		for i := range board {
+			if board[i].Players > 0 {
+				board[i].Score /= float64(board[i].Players)
+			}
+		}
+	}
+	sort.SliceStable(board, func(i, j int) bool {
+		return board[i].Score > board[j].Score
+	})
+	return board
 }
+
+type lbScore = protocol.Score
+
+type lbTeamScore = protocol.TeamScore
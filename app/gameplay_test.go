@@ -0,0 +1,301 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testGameplay stores the game with its tasks in a MemoryStore and returns a gameplay of them on a
+// fake clock.
+func testGameplay(t *testing.T, game *Game, tasks ...*Task) (*gameplay, *FakeClock) {
+	store := NewMemoryStore()
+	prev := DefaultStore
+	DefaultStore = store
+	t.Cleanup(func() { DefaultStore = prev })
+
+	game = store.AddGame(game, tasks...)
+	clock := NewFakeClock(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+	gp := newGameplayWithTasks(game, game.GetTasks(), clock)
+	gp.Start(game, clock.Now())
+	return gp, clock
+}
+
+// testPlayer adds a player of the game to the gameplay.
+func testPlayer(gp *gameplay, name string) *Player {
+	player := &Player{Game: &Game{ID: 1}, Name: name}
+	gp.Init(player)
+	return player
+}
+
+// playTask runs the next task, letting the players move after the given seconds, and returns once
+// the task is scored.
+func playTask(t *testing.T, gp *gameplay, clock *FakeClock, moves map[int]func()) {
+	t.Helper()
+
+	ticks := make(chan int)
+	finished := make(chan struct{})
+	task := gp.NextTask(func(timer int) { ticks <- timer }, func(*gameplay, *Task) func() {
+		return func() { close(finished) }
+	})
+	if task == nil {
+		t.Fatal("no task to play")
+	}
+	for second := 0; second < task.TimeToAnswer; second++ {
+		if move, ok := moves[second]; ok {
+			move()
+		}
+		clock.Advance(time.Second)
+		select {
+		case <-ticks:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no tick after %d seconds", second+1)
+		}
+	}
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task did not finish")
+	}
+}
+
+func TestNextTaskUnlocksBeforeFinishing(t *testing.T) {
+	gp, clock := testGameplay(t, &Game{Type: GameTypeQuiz},
+		&Task{Question: "2 + 2", Answers: []string{"3", "4"}, CorrectAnswer: "4", TimeToAnswer: 1})
+	testPlayer(gp, "alice")
+
+	players := make(chan int)
+	gp.NextTask(func(int) {}, func(*gameplay, *Task) func() {
+		// the results are broadcast while Run may be waiting for the gameplay
+		return func() { players <- gp.NumPlayers() }
+	})
+	clock.Advance(time.Second)
+	select {
+	case n := <-players:
+		if n != 1 {
+			t.Errorf("got %d players, want 1", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the gameplay is still locked once the task is over")
+	}
+}
+
+func TestAnswerAndLeave(t *testing.T) {
+	tests := []struct {
+		gameType string
+		task     *Task
+		answers  [2]string
+	}{
+		{GameTypeQuiz, &Task{Question: "2 + 2", Answers: []string{"3", "4"}, CorrectAnswer: "4", TimeToAnswer: 3},
+			[2]string{"4", "4"}},
+		{GameTypeWoC, &Task{Question: "Height of Everest?", CorrectAnswer: "8848", TimeToAnswer: 3},
+			[2]string{"8800", "9000"}},
+	}
+	for _, tt := range tests {
+		gp, clock := testGameplay(t, &Game{Type: tt.gameType}, tt.task)
+		alice, bob := testPlayer(gp, "alice"), testPlayer(gp, "bob")
+
+		playTask(t, gp, clock, map[int]func(){
+			0: func() {
+				for i, player := range []*Player{alice, bob} {
+					if _, _, err := gp.Answer(player, tt.answers[i]); err != nil {
+						t.Fatalf("%s: %s: %v", tt.gameType, player.Name, err)
+					}
+				}
+				gp.Leave(bob)
+			},
+		})
+
+		board := gp.scores.Leaderboard()
+		if len(board) != 1 || board[0].Player != "alice" || board[0].Score <= 0 {
+			t.Errorf("%s: got leaderboard %+v, want alice alone with a score", tt.gameType, board)
+		}
+	}
+}
+
+func TestAutoEndWhenLastPlayerLeaves(t *testing.T) {
+	gp, _ := testGameplay(t, &Game{Type: GameTypeQuiz, Options: GameOptions{AutoEnd: true}},
+		&Task{Question: "2 + 2", Answers: []string{"3", "4"}, CorrectAnswer: "4", TimeToAnswer: 10})
+	alice, bob := testPlayer(gp, "alice"), testPlayer(gp, "bob")
+
+	finished := make(chan struct{})
+	gp.NextTask(func(int) {}, func(*gameplay, *Task) func() {
+		return func() { close(finished) }
+	})
+	if _, _, err := gp.Answer(alice, "4"); err != nil {
+		t.Fatal(err)
+	}
+	gp.Leave(bob)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the task goes on after the only player yet to answer left")
+	}
+}
+
+func TestStreaks(t *testing.T) {
+	tasks := make([]*Task, 4)
+	for i := range tasks {
+		tasks[i] = &Task{Question: fmt.Sprint(i + 1), Answers: []string{"a", "b"}, CorrectAnswer: "a", TimeToAnswer: 1}
+	}
+	gp, clock := testGameplay(t, &Game{Type: GameTypeQuiz, Options: GameOptions{
+		Scoring:  ScoringOptions{Strategy: ScoringFlat, StreakBonus: 0.5},
+		PowerUps: []string{PowerUpDoubleOrNothing},
+	}}, tasks...)
+	alice, bob, carol := testPlayer(gp, "alice"), testPlayer(gp, "bob"), testPlayer(gp, "carol")
+
+	tests := []struct {
+		answers map[*Player]string
+		armed   *Player
+		scores  map[*Player]float64
+		streaks map[string]int
+	}{
+		{
+			answers: map[*Player]string{alice: "a", bob: "a", carol: "a"},
+			scores:  map[*Player]float64{alice: 1000, bob: 1000, carol: 1000},
+			streaks: map[string]int{"alice": 1, "bob": 1, "carol": 1},
+		},
+		{
+			// bob arms a power-up for a task left unanswered, and carol misses it
+			answers: map[*Player]string{alice: "a"},
+			armed:   bob,
+			scores:  map[*Player]float64{alice: 1500, bob: -1000, carol: 0},
+			streaks: map[string]int{"alice": 2},
+		},
+		{
+			answers: map[*Player]string{alice: "b", bob: "a", carol: "a"},
+			scores:  map[*Player]float64{alice: 0, bob: 1000, carol: 1000},
+			streaks: map[string]int{"bob": 1, "carol": 1},
+		},
+		{
+			answers: map[*Player]string{alice: "a", bob: "a", carol: "b"},
+			scores:  map[*Player]float64{alice: 1000, bob: 1500, carol: 0},
+			streaks: map[string]int{"alice": 1, "bob": 2},
+		},
+	}
+	for i, tt := range tests {
+		if tt.armed != nil && !gp.ArmPowerUp(tt.armed, PowerUpDoubleOrNothing) {
+			t.Fatalf("task %d: %s cannot arm the power-up", i+1, tt.armed.Name)
+		}
+		playTask(t, gp, clock, map[int]func(){
+			0: func() {
+				for player, answer := range tt.answers {
+					if _, _, err := gp.Answer(player, answer); err != nil {
+						t.Fatalf("task %d: %s: %v", i+1, player.Name, err)
+					}
+				}
+			},
+		})
+		for player, want := range tt.scores {
+			if got := gp.scores[player][i]; got != want {
+				t.Errorf("task %d: %s scored %v, want %v", i+1, player.Name, got, want)
+			}
+		}
+		if got := gp.Streaks(); fmt.Sprint(got) != fmt.Sprint(tt.streaks) {
+			t.Errorf("task %d: got streaks %v, want %v", i+1, got, tt.streaks)
+		}
+	}
+}
+
+func TestGetPlayerStreak(t *testing.T) {
+	gp, _ := testGameplay(t, &Game{Type: GameTypeQuiz, Mode: GameModeSelfPaced},
+		&Task{Question: "1", Answers: []string{"a", "b"}, CorrectAnswer: "a"},
+		&Task{Question: "2", Answers: []string{"a", "b"}, CorrectAnswer: "a"},
+		&Task{Question: "3", Answers: []string{"a", "b"}, CorrectAnswer: "a"},
+		&Task{Question: "4", Answers: []string{"a", "b"}, CorrectAnswer: "a"})
+	game := GetGame(1)
+	startedAt := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	UpdateGameStartedAt(game, startedAt)
+
+	for i, score := range []float64{1000, 0, 1000, 500} {
+		InsertScores(&Score{Game: game, Task: gp.tasks[i], Player: "alice", Score: score,
+			CreatedAt: startedAt.Add(time.Duration(i+1) * time.Second)})
+		want := []int{1, 0, 1, 2}[i]
+		if got := GetPlayerStreak(game, "alice", ""); got != want {
+			t.Errorf("after task %d: got streak %d, want %d", i+1, got, want)
+		}
+	}
+	if got := GetPlayerStreak(game, "bob", ""); got != 0 {
+		t.Errorf("got streak %d for a player without answers", got)
+	}
+}
+
+func TestAssignTeam(t *testing.T) {
+	gp, _ := testGameplay(t, &Game{Type: GameTypeQuiz, Options: GameOptions{Teams: []string{"red", "blue"}}},
+		&Task{Question: "2 + 2", Answers: []string{"3", "4"}, CorrectAnswer: "4"})
+	join := func(name, team string) *Player {
+		player := &Player{Game: &Game{ID: 1}, Name: name, Team: gp.AssignTeam(team)}
+		gp.Init(player)
+		return player
+	}
+
+	// the players fill the teams in turn, unless they ask for one
+	players := make(map[string]*Player)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		players[name] = join(name, "")
+	}
+	players["dave"] = join("dave", "red")
+	players["erin"] = join("erin", "green")
+	// with alice gone, the teams are even again
+	gp.Leave(players["alice"])
+	players["frank"] = join("frank", "")
+
+	want := map[string]string{"alice": "red", "bob": "blue", "carol": "red", "dave": "red", "erin": "blue",
+		"frank": "red"}
+	for name, player := range players {
+		if player.Team != want[name] {
+			t.Errorf("%s: got team %q, want %q", name, player.Team, want[name])
+		}
+	}
+
+	noTeams, _ := testGameplay(t, &Game{Type: GameTypeQuiz}, &Task{Question: "2 + 2"})
+	if team := noTeams.AssignTeam("red"); team != "" {
+		t.Errorf("got team %q in a game without teams", team)
+	}
+}
+
+func TestTeamLeaderboard(t *testing.T) {
+	scores := map[string]struct {
+		team  string
+		score float64
+	}{
+		"alice": {"red", 3000},
+		"bob":   {"red", 1000},
+		"carol": {"blue", 1500},
+		"dave":  {"blue", 500},
+		"erin":  {"blue", 5000},
+		"frank": {"", 9000},
+	}
+	tests := []struct {
+		scoring string
+		want    string
+	}{
+		{TeamScoringSum, "[{red 4000 2} {blue 2000 2} {green 0 0}]"},
+		{TeamScoringAverage, "[{red 2000 2} {blue 1000 2} {green 0 0}]"},
+		{TeamScoringBest, "[{red 3000 2} {blue 1500 2} {green 0 0}]"},
+	}
+	for _, tt := range tests {
+		gp, _ := testGameplay(t, &Game{Type: GameTypeQuiz, Options: GameOptions{
+			Teams:       []string{"green", "blue", "red"},
+			TeamScoring: tt.scoring,
+		}}, &Task{Question: "2 + 2", Answers: []string{"3", "4"}, CorrectAnswer: "4"})
+		for name, sc := range scores {
+			player := &Player{Game: &Game{ID: 1}, Name: name, Team: sc.team}
+			gp.Init(player)
+			gp.scores[player][0] = sc.score
+			// erin scored the most, but left before the end
+			if name == "erin" {
+				gp.Leave(player)
+			}
+		}
+
+		board := gp.TeamLeaderboard()
+		got := make([]string, len(board))
+		for i, team := range board {
+			got[i] = fmt.Sprintf("{%s %v %d}", team.Team, team.Score, team.Players)
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.scoring, got, tt.want)
+		}
+	}
+}
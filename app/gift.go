@@ -0,0 +1,234 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// giftBlankMarker stands in for the answer of a missing word question, e.g. "The {=cat ~dog} meows".
+const giftBlankMarker = "_____"
+
+var (
+	giftFormatRe = regexp.MustCompile(`^\[(html|moodle|plain|markdown)]`)
+	giftWeightRe = regexp.MustCompile(`^%(-?[0-9.]+)%`)
+)
+
+// giftIndex returns the index of the first of the characters in s that is not escaped, or -1.
+func giftIndex(s string, chars string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if strings.IndexByte(chars, s[i]) >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+func giftUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == 'n' {
+				b.WriteByte('\n')
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// giftDepth returns the depth of the unescaped braces at the end of s, starting from depth.
+func giftDepth(s string, depth int) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+	}
+	return depth
+}
+
+// parseQuizGIFT reads the questions of the Moodle GIFT format that kakadoo can ask: multiple choice
+// with a single correct answer, true/false, missing word and, for woc games, numerical questions.
+// Titles, feedback and weights are dropped; the last part of the category labels the questions for
+// the bank.
+func parseQuizGIFT(r io.Reader, base Game) (*quizImport, error) {
+	f := &quizImport{game: newImportedGame(base)}
+
+	var block []string
+	var category string
+	start, depth := 0, 0
+	flush := func() {
+		if len(block) > 0 {
+			where := fmt.Sprintf("line %d", start)
+			if task, err := parseGIFTQuestion(strings.Join(block, "\n"), f.game.Type); err != nil {
+				f.fail(where, err)
+			} else {
+				f.addLabelled(task, QuestionLabels{Category: category}, where)
+			}
+		}
+		block = nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if depth == 0 {
+			if text == "" {
+				flush()
+				continue
+			}
+			if strings.HasPrefix(text, "//") {
+				continue
+			}
+			if strings.HasPrefix(text, "$CATEGORY:") {
+				flush()
+				// e.g. $CATEGORY: $course$/top/Geography
+				category = strings.TrimSpace(text[len("$CATEGORY:"):])
+				category = category[strings.LastIndex(category, "/")+1:]
+				continue
+			}
+		}
+		if len(block) == 0 {
+			start = line
+		}
+		block = append(block, text)
+		depth = giftDepth(text, depth)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return f, nil
+}
+
+func parseGIFTQuestion(text string, gameType string) (*Task, error) {
+	if strings.HasPrefix(text, "::") {
+		if end := strings.Index(text[2:], "::"); end >= 0 {
+			text = strings.TrimSpace(text[end+4:])
+		}
+	}
+	text = giftFormatRe.ReplaceAllString(text, "")
+
+	open := giftIndex(text, "{")
+	if open < 0 {
+		return nil, errors.New("descriptions without answers are not supported")
+	}
+	end := giftIndex(text[open:], "}")
+	if end < 0 {
+		return nil, errors.New("answers are not closed with }")
+	}
+	end += open
+	answers := strings.TrimSpace(text[open+1 : end])
+
+	task := newImportedTask()
+	task.Question = giftUnescape(text[:open])
+	if after := giftUnescape(text[end+1:]); after != "" {
+		task.Question = strings.TrimSpace(task.Question + " " + giftBlankMarker + " " + after)
+	}
+
+	switch {
+	case answers == "":
+		return nil, errors.New("essay questions are not supported")
+	case answers[0] == '#':
+		if gameType != GameTypeWoC {
+			return nil, errors.New("numerical questions are only supported by woc games")
+		}
+		value := strings.TrimSpace(answers[1:])
+		if strings.HasPrefix(value, "=") {
+			// a single answer may be marked correct, e.g. {#=3:1}
+			value = giftWeightRe.ReplaceAllString(value[1:], "")
+		}
+		// every answer has its feedback, e.g. {#=3:1#right =%50%2:1#close}, so they are counted first
+		if giftIndex(value, "=~") >= 0 {
+			return nil, errors.New("numerical questions with more than one answer are not supported")
+		}
+		if i := giftIndex(value, "#"); i >= 0 {
+			value = value[:i]
+		}
+		value = giftUnescape(value)
+		// an exact value with a tolerance or a range, of which the middle is taken
+		if i := strings.Index(value, ".."); i >= 0 {
+			min, err1 := strconv.ParseFloat(strings.TrimSpace(value[:i]), 64)
+			max, err2 := strconv.ParseFloat(strings.TrimSpace(value[i+2:]), 64)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("range %q is not numeric", value)
+			}
+			value = strconv.FormatFloat((min+max)/2, 'f', -1, 64)
+		} else if i := strings.Index(value, ":"); i >= 0 {
+			value = value[:i]
+		}
+		task.CorrectAnswer = strings.TrimSpace(value)
+		return task, nil
+	}
+
+	trueFalse := answers
+	if i := giftIndex(trueFalse, "#"); i >= 0 {
+		trueFalse = trueFalse[:i]
+	}
+	switch strings.ToUpper(strings.TrimSpace(trueFalse)) {
+	case "T", "TRUE":
+		task.Answers, task.CorrectAnswer = []string{"True", "False"}, "True"
+		return task, nil
+	case "F", "FALSE":
+		task.Answers, task.CorrectAnswer = []string{"True", "False"}, "False"
+		return task, nil
+	}
+
+	if answers[0] != '=' && answers[0] != '~' {
+		return nil, errors.New("answers must start with = or ~")
+	}
+	wrong := 0
+	for answers != "" {
+		mark := answers[0]
+		answers = answers[1:]
+		next := giftIndex(answers, "=~")
+		option := answers
+		if next >= 0 {
+			option, answers = answers[:next], answers[next:]
+		} else {
+			answers = ""
+		}
+		if i := giftIndex(option, "#"); i >= 0 {
+			option = option[:i]
+		}
+		if strings.Contains(option, "->") {
+			return nil, errors.New("matching questions are not supported")
+		}
+		correct := mark == '='
+		if m := giftWeightRe.FindStringSubmatch(option); m != nil {
+			weight, _ := strconv.ParseFloat(m[1], 64)
+			correct = correct || weight >= 100
+			option = option[len(m[0]):]
+		}
+
+		option = giftUnescape(option)
+		task.Answers = append(task.Answers, option)
+		if !correct {
+			wrong++
+		} else if task.CorrectAnswer != "" {
+			return nil, errors.New("questions with more than one correct answer are not supported")
+		} else {
+			task.CorrectAnswer = option
+		}
+	}
+	if wrong == 0 {
+		return nil, errors.New("short answer questions are not supported")
+	}
+	if task.CorrectAnswer == "" {
+		return nil, errors.New("no answer is marked correct with =")
+	}
+	return task, nil
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseGIFTQuestion(t *testing.T) {
+	tests := []struct {
+		text          string
+		gameType      string
+		question      string
+		answers       []string
+		correctAnswer string
+		err           string
+	}{
+		{text: "What is 2 + 2? {=4 ~3 ~5}", question: "What is 2 + 2?", answers: []string{"4", "3", "5"}, correctAnswer: "4"},
+		{text: "::Sum::What is 2 + 2? {~3 =4}", question: "What is 2 + 2?", answers: []string{"3", "4"}, correctAnswer: "4"},
+		{text: "[html]<b>Bold</b> move? {=yes ~no}", question: "<b>Bold</b> move?", answers: []string{"yes", "no"},
+			correctAnswer: "yes"},
+		{text: "::Title::[markdown]Pick one {=a ~b}", question: "Pick one", answers: []string{"a", "b"}, correctAnswer: "a"},
+		{text: `1 \= 1\: true\{\}\#? {=yes \~ sure ~no\nway}`, question: "1 = 1: true{}#?",
+			answers: []string{"yes ~ sure", "no\nway"}, correctAnswer: "yes ~ sure"},
+		{text: "The {=cat ~dog} meows.", question: "The _____ meows.", answers: []string{"cat", "dog"}, correctAnswer: "cat"},
+		{text: "The sky is blue {T}", question: "The sky is blue", answers: []string{"True", "False"}, correctAnswer: "True"},
+		{text: "The sun is cold {FALSE#It is not}", question: "The sun is cold", answers: []string{"True", "False"},
+			correctAnswer: "False"},
+		{text: "Pick {~%100%right ~%50%half ~%-50%wrong}", question: "Pick", answers: []string{"right", "half", "wrong"},
+			correctAnswer: "right"},
+		{text: "Pick {=a#Well done ~b#Try again}", question: "Pick", answers: []string{"a", "b"}, correctAnswer: "a"},
+		{text: "Height of Everest? {#8848}", gameType: GameTypeWoC, question: "Height of Everest?", correctAnswer: "8848"},
+		{text: "Height of Everest? {#8848:50}", gameType: GameTypeWoC, question: "Height of Everest?",
+			correctAnswer: "8848"},
+		{text: "Height of Everest? {#8800..8900#Close enough}", gameType: GameTypeWoC, question: "Height of Everest?",
+			correctAnswer: "8850"},
+		{text: "Height of Everest? {#=8848:50#Right}", gameType: GameTypeWoC, question: "Height of Everest?",
+			correctAnswer: "8848"},
+		{text: "Pi? {#=3:1 =%50%2:1}", gameType: GameTypeWoC,
+			err: "numerical questions with more than one answer are not supported"},
+		{text: "Pi? {#=3:1#Close =%50%2:1#Far}", gameType: GameTypeWoC,
+			err: "numerical questions with more than one answer are not supported"},
+		{text: "Pi? {#a..b}", gameType: GameTypeWoC, err: `range "a..b" is not numeric`},
+		{text: "Pi? {#3}", err: "numerical questions are only supported by woc games"},
+		{text: "Write an essay {}", err: "essay questions are not supported"},
+		{text: "Just a description", err: "descriptions without answers are not supported"},
+		{text: "Pick {=a ~b", err: "answers are not closed with }"},
+		{text: "Pick {a b}", err: "answers must start with = or ~"},
+		{text: "Match {=a -> 1 =b -> 2}", err: "matching questions are not supported"},
+		{text: "Name a cat {=Tom}", err: "short answer questions are not supported"},
+		{text: "Pick {=a =b ~c}", err: "questions with more than one correct answer are not supported"},
+		{text: "Pick {~a ~b}", err: "no answer is marked correct with ="},
+	}
+	for _, tt := range tests {
+		if tt.gameType == "" {
+			tt.gameType = GameTypeQuiz
+		}
+		task, err := parseGIFTQuestion(tt.text, tt.gameType)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, want %q", tt.text, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.text, err)
+			continue
+		}
+		if task.Question != tt.question || task.CorrectAnswer != tt.correctAnswer ||
+			fmt.Sprintf("%q", task.Answers) != fmt.Sprintf("%q", tt.answers) {
+			t.Errorf("%q: got %q %q %q, want %q %q %q", tt.text, task.Question, task.Answers, task.CorrectAnswer,
+				tt.question, tt.answers, tt.correctAnswer)
+		}
+		if err = task.Validate(tt.gameType); err != nil {
+			t.Errorf("%q: %v", tt.text, err)
+		}
+	}
+}
+
+func TestParseQuizGIFT(t *testing.T) {
+	const file = "\ufeff// a comment\n" +
+		"$CATEGORY: $course$/top/Geography\n" +
+		"\n" +
+		"::Capital::Capital of France? {\n" +
+		"  =Paris\n" +
+		"  ~Lyon\n" +
+		"}\n" +
+		"\n" +
+		"Write an essay {}\n" +
+		"\n" +
+		"$CATEGORY: Science\n" +
+		"Water boils at 100C {T}\n" +
+		"\n" +
+		"Pick {~a ~b}\n"
+	f, err := parseQuizGIFT(strings.NewReader(file), Game{Type: GameTypeQuiz})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantWhere := []string{"line 4", "line 12"}
+	wantCategories := []string{"Geography", "Science"}
+	wantQuestions := []string{"Capital of France?", "Water boils at 100C"}
+	if len(f.tasks) != len(wantWhere) {
+		t.Fatalf("got %d tasks, want %d", len(f.tasks), len(wantWhere))
+	}
+	for i, task := range f.tasks {
+		if f.where[i] != wantWhere[i] || f.labels[i].Category != wantCategories[i] || task.Question != wantQuestions[i] {
+			t.Errorf("task %d: got %q at %s in %q", i+1, task.Question, f.where[i], f.labels[i].Category)
+		}
+	}
+
+	wantErrs := "line 9: essay questions are not supported\nline 14: no answer is marked correct with ="
+	if f.errs.Error() != wantErrs {
+		t.Errorf("got errors\n%v\nwant\n%s", f.errs, wantErrs)
+	}
+}
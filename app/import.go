@@ -0,0 +1,385 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	QuizFormatJSON = "json"
+	QuizFormatCSV  = "csv"
+	QuizFormatGIFT = "gift"
+)
+
+// QuizFileVersion is the version of the JSON format of exported quizzes.
+const QuizFileVersion = 1
+
+const (
+	defaultTimeToAnswer = 10
+	defaultTaskGap      = 5
+)
+
+// QuizFile is a game with its tasks in the native JSON format, which carries them between kakadoo
+// instances. Schedules and sessions stay behind.
+type QuizFile struct {
+	Version int            `json:"version"`
+	Type    string         `json:"type"`
+	Mode    string         `json:"mode,omitempty"`
+	Title   string         `json:"title"`
+	Author  string         `json:"author"`
+	TaskGap int            `json:"task_gap,omitempty"`
+	Options GameOptions    `json:"options"`
+	Tasks   []QuizFileTask `json:"tasks"`
+}
+
+// QuizFileTask is a task of a QuizFile, which asks either the question given, the question of the bank
+// with the ID or a question drawn from the bank. The labels are for importing the tasks into the bank.
+type QuizFileTask struct {
+	QuestionID    int             `json:"question_id,omitempty"`
+	Draw          *QuestionFilter `json:"draw,omitempty"`
+	Question      string          `json:"question,omitempty"`
+	Answers       []string        `json:"answers,omitempty"`
+	Image         bool            `json:"image,omitempty"`
+	CorrectAnswer string          `json:"correct_answer,omitempty"`
+	TimeToAnswer  int             `json:"time_to_answer,omitempty"`
+	Multiplier    float64         `json:"multiplier,omitempty"`
+	AllowChange   bool            `json:"allow_change,omitempty"`
+	Category      string          `json:"category,omitempty"`
+	Difficulty    string          `json:"difficulty,omitempty"`
+	Tags          []string        `json:"tags,omitempty"`
+}
+
+// ExportQuiz returns the game and its tasks in the native JSON format. Questions of the bank are
+// written out, as the bank of another instance differs, but draws are kept.
+func ExportQuiz(game *Game) *QuizFile {
+	f := &QuizFile{
+		Version: QuizFileVersion,
+		Type:    game.Type,
+		Mode:    game.Mode,
+		Title:   game.Title,
+		Author:  game.Author,
+		TaskGap: game.TaskGap,
+		Options: game.Options,
+		Tasks:   make([]QuizFileTask, 0),
+	}
+	for _, task := range game.GetTasks() {
+		if task.Draw != nil {
+			f.Tasks = append(f.Tasks, QuizFileTask{Draw: task.Draw})
+			continue
+		}
+		f.Tasks = append(f.Tasks, QuizFileTask{
+			Question:      task.Question,
+			Answers:       task.Answers,
+			Image:         task.Image,
+			CorrectAnswer: task.CorrectAnswer,
+			TimeToAnswer:  task.TimeToAnswer,
+			Multiplier:    task.Multiplier,
+			AllowChange:   task.AllowChange,
+		})
+	}
+	return f
+}
+
+// ImportError is a problem with a task of an imported file, found at the row of a CSV file, the
+// line of a GIFT file or the task of a JSON file.
+type ImportError struct {
+	Where string
+	Err   error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Where, e.Err)
+}
+
+// ImportErrors are all the problems of an imported file, so that they can be fixed at once.
+type ImportErrors []*ImportError
+
+func (e ImportErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// QuizFormat guesses the format of an imported file by its name.
+func QuizFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return QuizFormatJSON
+	case ".csv":
+		return QuizFormatCSV
+	case ".gift", ".txt":
+		return QuizFormatGIFT
+	}
+	return ""
+}
+
+// ImportQuiz reads a game with its tasks in the format and stores it. CSV and GIFT files carry only
+// the tasks, so the type, the mode, the title and the author of the game come from base; the title
+// and the author of base replace those of a JSON file. Nothing is stored if any of the tasks is
+// invalid, and the error is ImportErrors then.
+func ImportQuiz(format string, r io.Reader, base Game) (*Game, error) {
+	f, err := parseQuiz(format, r, base)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.validate(); err != nil {
+		return nil, err
+	}
+	InsertGame(f.game, f.tasks)
+	return f.game, nil
+}
+
+func parseQuiz(format string, r io.Reader, base Game) (*quizImport, error) {
+	switch format {
+	case QuizFormatJSON:
+		return parseQuizJSON(r, base)
+	case QuizFormatCSV:
+		return parseQuizCSV(r, base)
+	case QuizFormatGIFT:
+		return parseQuizGIFT(r, base)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// quizImport is a parsed file: the game, its tasks with where they are in the file and their labels,
+// and their problems.
+type quizImport struct {
+	game   *Game
+	tasks  []*Task
+	where  []string
+	labels []QuestionLabels
+	errs   ImportErrors
+}
+
+// add validates the task as it comes, so that the problems are listed in the order of the file.
+func (f *quizImport) add(task *Task, where string) {
+	f.addLabelled(task, QuestionLabels{}, where)
+}
+
+func (f *quizImport) addLabelled(task *Task, labels QuestionLabels, where string) {
+	if err := task.Validate(f.game.Type); err != nil {
+		f.fail(where, err)
+	}
+	f.tasks = append(f.tasks, task)
+	f.where = append(f.where, where)
+	f.labels = append(f.labels, labels)
+}
+
+func (f *quizImport) fail(where string, err error) {
+	f.errs = append(f.errs, &ImportError{where, err})
+}
+
+func (f *quizImport) validate() error {
+	game := f.game
+	if game.Title == "" {
+		return errors.New("title is empty")
+	}
+	if game.Author == "" {
+		return errors.New("author is empty")
+	}
+	switch game.Type {
+	case GameTypeQuiz, GameTypeWoC, GameTypeFindCat:
+	default:
+		return fmt.Errorf("unknown game type %q", game.Type)
+	}
+	switch game.Mode {
+	case "", GameModeLive, GameModeSelfPaced:
+	default:
+		return fmt.Errorf("unknown game mode %q", game.Mode)
+	}
+	if err := game.Options.Validate(); err != nil {
+		return fmt.Errorf("options: %v", err)
+	}
+	if len(f.tasks) == 0 && len(f.errs) == 0 {
+		return errors.New("there are no tasks")
+	}
+	if len(f.errs) > 0 {
+		return f.errs
+	}
+	return checkDraws(f.tasks)
+}
+
+func newImportedTask() *Task {
+	return &Task{TimeToAnswer: defaultTimeToAnswer, Multiplier: 1}
+}
+
+func newImportedGame(base Game) *Game {
+	game := base
+	game.ID = 0
+	game.LastStartedAt = nil
+	if game.TaskGap == 0 {
+		game.TaskGap = defaultTaskGap
+	}
+	return &game
+}
+
+func parseQuizJSON(r io.Reader, base Game) (*quizImport, error) {
+	var qf QuizFile
+	if err := json.NewDecoder(r).Decode(&qf); err != nil {
+		return nil, err
+	}
+	if qf.Version != QuizFileVersion {
+		return nil, fmt.Errorf("unsupported version %d", qf.Version)
+	}
+
+	f := &quizImport{game: newImportedGame(Game{
+		Type:    qf.Type,
+		Mode:    qf.Mode,
+		Title:   qf.Title,
+		Author:  qf.Author,
+		TaskGap: qf.TaskGap,
+		Options: qf.Options,
+	})}
+	if base.Title != "" {
+		f.game.Title = base.Title
+	}
+	if base.Author != "" {
+		f.game.Author = base.Author
+	}
+	for i, t := range qf.Tasks {
+		where := fmt.Sprintf("task %d", i+1)
+		task := newImportedTask()
+		switch {
+		case t.Draw != nil:
+			task.Draw = t.Draw
+		case t.QuestionID != 0:
+			q := GetQuestion(t.QuestionID)
+			if q == nil {
+				f.fail(where, fmt.Errorf("there is no question %d in the bank", t.QuestionID))
+				continue
+			}
+			qt := q.task()
+			task = &qt
+		default:
+			task.Question = t.Question
+			task.Answers = t.Answers
+			task.Image = t.Image
+			task.CorrectAnswer = t.CorrectAnswer
+			task.AllowChange = t.AllowChange
+			if t.TimeToAnswer != 0 {
+				task.TimeToAnswer = t.TimeToAnswer
+			}
+			if t.Multiplier != 0 {
+				task.Multiplier = t.Multiplier
+			}
+		}
+		f.addLabelled(task, QuestionLabels{t.Category, t.Difficulty, t.Tags}, where)
+	}
+	return f, nil
+}
+
+// parseQuizCSV reads a task per row. The header names the columns: question, correct_answer,
+// time_to_answer, multiplier, allow_change, image and any number of columns starting with "answer"
+// for the answers to choose from, in order. The category, difficulty and tags columns, tags separated
+// by commas, label the questions for the bank.
+func parseQuizCSV(r io.Reader, base Game) (*quizImport, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("header: %v", err)
+	}
+
+	columns := make(map[string]int)
+	answerColumns := make([]int, 0)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		name = strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+		switch name {
+		case "question", "correct_answer", "time_to_answer", "multiplier", "allow_change", "image", "category",
+			"difficulty", "tags":
+			columns[name] = i
+		default:
+			if strings.HasPrefix(name, "answer") {
+				answerColumns = append(answerColumns, i)
+			}
+		}
+	}
+	if _, ok := columns["question"]; !ok {
+		return nil, errors.New("header: no question column")
+	}
+	if _, ok := columns["correct_answer"]; !ok {
+		return nil, errors.New("header: no correct_answer column")
+	}
+
+	f := &quizImport{game: newImportedGame(base)}
+	// rows are counted as in a spreadsheet, the header being the first
+	for row := 2; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		where := fmt.Sprintf("row %d", row)
+		if err != nil {
+			return nil, &ImportError{where, err}
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		cell := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		task := newImportedTask()
+		task.Question = cell("question")
+		task.CorrectAnswer = cell("correct_answer")
+		for _, i := range answerColumns {
+			if i < len(record) && strings.TrimSpace(record[i]) != "" {
+				task.Answers = append(task.Answers, strings.TrimSpace(record[i]))
+			}
+		}
+		if v := cell("time_to_answer"); v != "" {
+			if task.TimeToAnswer, err = strconv.Atoi(v); err != nil {
+				f.fail(where, fmt.Errorf("time to answer %q is not a number", v))
+				continue
+			}
+		}
+		if v := cell("multiplier"); v != "" {
+			if task.Multiplier, err = strconv.ParseFloat(v, 64); err != nil {
+				f.fail(where, fmt.Errorf("multiplier %q is not a number", v))
+				continue
+			}
+		}
+		if v := cell("allow_change"); v != "" {
+			if task.AllowChange, err = parseImportBool(v); err != nil {
+				f.fail(where, err)
+				continue
+			}
+		}
+		if v := cell("image"); v != "" {
+			if task.Image, err = parseImportBool(v); err != nil {
+				f.fail(where, err)
+				continue
+			}
+		}
+		labels := QuestionLabels{Category: cell("category"), Difficulty: strings.ToLower(cell("difficulty"))}
+		for _, tag := range strings.Split(cell("tags"), ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				labels.Tags = append(labels.Tags, tag)
+			}
+		}
+		f.addLabelled(task, labels, where)
+	}
+	return f, nil
+}
+
+func parseImportBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "1", "true", "yes", "y":
+		return true, nil
+	case "0", "false", "no", "n":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is neither yes nor no", v)
+}
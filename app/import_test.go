@@ -0,0 +1,92 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseQuizCSV(t *testing.T) {
+	const file = "\ufeffQuestion,Answer 1,Answer 2,Correct answer,Time to answer,Multiplier,Allow-change,Image," +
+		"Category,Difficulty,Tags\n" +
+		"2 + 2,3,4,4,20,2,yes,,Maths,Easy,\"arithmetic, quick\"\n" +
+		",,,,,,,,,,\n" +
+		"Capital of France, Paris ,Lyon,Paris\n" +
+		"Late,a,b,a,soon,,,,,,\n" +
+		"Changing,a,b,a,,,maybe,,,,\n" +
+		"Wrong,a,b,c,,,,,,,\n" +
+		"/static/cat.jpg,,,\"rect:0,0,10,10\",,,,yes,,,\n" +
+		"Bad image,a,b,a,,,,y,,,\n" +
+		"Poisoned,a,b,a,,NaN,,,,,\n" +
+		"Endless,a,b,a,,+Inf,,,,,\n" +
+		"Negative,a,b,a,,-1,,,,,\n"
+	f, err := parseQuizCSV(strings.NewReader(file), Game{Type: GameTypeQuiz})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		where  string
+		task   Task
+		labels QuestionLabels
+	}{
+		{"row 2", Task{Question: "2 + 2", Answers: []string{"3", "4"}, CorrectAnswer: "4", TimeToAnswer: 20, Multiplier: 2,
+			AllowChange: true}, QuestionLabels{"Maths", "easy", []string{"arithmetic", "quick"}}},
+		{"row 4", Task{Question: "Capital of France", Answers: []string{"Paris", "Lyon"}, CorrectAnswer: "Paris",
+			TimeToAnswer: defaultTimeToAnswer, Multiplier: 1}, QuestionLabels{}},
+		{"row 7", Task{Question: "Wrong", Answers: []string{"a", "b"}, CorrectAnswer: "c",
+			TimeToAnswer: defaultTimeToAnswer, Multiplier: 1}, QuestionLabels{}},
+		{"row 8", Task{Question: "/static/cat.jpg", Image: true, CorrectAnswer: "rect:0,0,10,10",
+			TimeToAnswer: defaultTimeToAnswer, Multiplier: 1}, QuestionLabels{}},
+		{"row 9", Task{Question: "Bad image", Answers: []string{"a", "b"}, Image: true, CorrectAnswer: "a",
+			TimeToAnswer: defaultTimeToAnswer, Multiplier: 1}, QuestionLabels{}},
+		{"row 10", Task{Question: "Poisoned", Answers: []string{"a", "b"}, CorrectAnswer: "a",
+			TimeToAnswer: defaultTimeToAnswer, Multiplier: math.NaN()}, QuestionLabels{}},
+		{"row 11", Task{Question: "Endless", Answers: []string{"a", "b"}, CorrectAnswer: "a",
+			TimeToAnswer: defaultTimeToAnswer, Multiplier: math.Inf(1)}, QuestionLabels{}},
+		{"row 12", Task{Question: "Negative", Answers: []string{"a", "b"}, CorrectAnswer: "a",
+			TimeToAnswer: defaultTimeToAnswer, Multiplier: -1}, QuestionLabels{}},
+	}
+	if len(f.tasks) != len(want) {
+		t.Fatalf("got %d tasks, want %d", len(f.tasks), len(want))
+	}
+	for i, w := range want {
+		if f.where[i] != w.where || fmt.Sprintf("%+v", *f.tasks[i]) != fmt.Sprintf("%+v", w.task) ||
+			fmt.Sprintf("%q", f.labels[i]) != fmt.Sprintf("%q", w.labels) {
+			t.Errorf("task %d: got %s %+v %q, want %s %+v %q", i+1, f.where[i], *f.tasks[i], f.labels[i],
+				w.where, w.task, w.labels)
+		}
+	}
+
+	wantErrs := []string{
+		`row 5: time to answer "soon" is not a number`,
+		`row 6: "maybe" is neither yes nor no`,
+		`row 7: correct answer "c" is not one of the answers`,
+		`row 9: image tasks have no answers to choose from`,
+		`row 10: multiplier must be a finite number`,
+		`row 11: multiplier must be a finite number`,
+		`row 12: multiplier must be positive`,
+	}
+	if f.errs.Error() != strings.Join(wantErrs, "\n") {
+		t.Errorf("got errors\n%v\nwant\n%s", f.errs, strings.Join(wantErrs, "\n"))
+	}
+}
+
+func TestParseQuizCSVHeader(t *testing.T) {
+	tests := []struct {
+		file string
+		err  string
+	}{
+		{"", "header: EOF"},
+		{"answer,correct_answer\n", "header: no question column"},
+		{"question,answer\n", "header: no correct_answer column"},
+		{"question,correct_answer\n\"open,a\n", "row 2: "},
+	}
+	for _, tt := range tests {
+		_, err := parseQuizCSV(strings.NewReader(tt.file), Game{Type: GameTypeQuiz})
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %q", tt.file, err, tt.err)
+		}
+	}
+}
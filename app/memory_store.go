@@ -0,0 +1,437 @@
+package app
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryDraw struct {
+	taskID  int
+	session int64
+}
+
+type memoryDelivery struct {
+	gameID      int
+	taskID      int
+	player      string
+	playerKey   string
+	deliveredAt time.Time
+}
+
+// MemoryStore keeps everything in memory, for tests and for trying the game out without a database.
+// It hands out copies, like the database does, so changes to a game only stick through the store.
+type MemoryStore struct {
+	games      []*Game
+	tasks      map[int][]*Task
+	scores     []*Score
+	deliveries []memoryDelivery
+	events     []*Event
+	questions  []*Question
+	draws      map[memoryDraw]int
+	lastID     int
+	mu         sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tasks: make(map[int][]*Task), draws: make(map[memoryDraw]int)}
+}
+
+// AddGame adds the game with its tasks, giving them IDs, and returns a copy of the game.
+func (s *MemoryStore) AddGame(game *Game, tasks ...*Task) *Game {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	stored := *game
+	stored.ID = s.lastID
+	s.games = append(s.games, &stored)
+
+	s.tasks[stored.ID] = make([]*Task, len(tasks))
+	for i, task := range tasks {
+		s.lastID++
+		t := *task
+		t.ID = s.lastID
+		if t.Multiplier == 0 {
+			t.Multiplier = 1
+		}
+		s.tasks[stored.ID][i] = &t
+	}
+	copied := stored
+	return &copied
+}
+
+func (s *MemoryStore) InsertGame(game *Game, tasks []*Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	game.ID = s.lastID
+	stored := *game
+	s.games = append(s.games, &stored)
+
+	s.tasks[game.ID] = make([]*Task, len(tasks))
+	for i, task := range tasks {
+		s.lastID++
+		task.ID = s.lastID
+		t := *task
+		s.tasks[game.ID][i] = &t
+	}
+}
+
+func (s *MemoryStore) game(id int) *Game {
+	for _, game := range s.games {
+		if game.ID == id {
+			return game
+		}
+	}
+	return nil
+}
+
+// inSession reports whether the record made at the time belongs to the current session of the game.
+// As in SQL, nothing does until the game has been started.
+func (s *MemoryStore) inSession(gameID int, at time.Time) bool {
+	game := s.game(gameID)
+	return game != nil && game.LastStartedAt != nil && !at.Before(*game.LastStartedAt)
+}
+
+func (s *MemoryStore) GetGames() []*Game {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	games := make([]*Game, len(s.games))
+	for i, game := range s.games {
+		copied := *game
+		games[i] = &copied
+	}
+	return games
+}
+
+func (s *MemoryStore) GetScheduledGames(from, to time.Time) []*Game {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	games := make([]*Game, 0)
+	for _, game := range s.games {
+		if game.ScheduledAt == nil || game.ScheduledAt.Before(from) || game.ScheduledAt.After(to) {
+			continue
+		}
+		if game.LastStartedAt != nil && !game.LastStartedAt.Before(*game.ScheduledAt) {
+			continue
+		}
+		copied := *game
+		games = append(games, &copied)
+	}
+	sort.SliceStable(games, func(i, j int) bool {
+		return games[i].ScheduledAt.Before(*games[j].ScheduledAt)
+	})
+	return games
+}
+
+func (s *MemoryStore) GetGame(id int) *Game {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	game := s.game(id)
+	if game == nil {
+		return nil
+	}
+	copied := *game
+	return &copied
+}
+
+func (s *MemoryStore) GetTasks(game *Game) []*Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := make([]*Task, len(s.tasks[game.ID]))
+	for i, task := range s.tasks[game.ID] {
+		copied := *task
+		if task.Draw != nil {
+			copied.QuestionID = 0
+			if game.LastStartedAt != nil {
+				copied.QuestionID = s.draws[memoryDraw{task.ID, game.LastStartedAt.UnixNano()}]
+			}
+		}
+		if q := s.question(copied.QuestionID); q != nil {
+			t := q.task()
+			t.ID, t.Draw = copied.ID, copied.Draw
+			copied = t
+		}
+		tasks[i] = &copied
+	}
+	return tasks
+}
+
+func (s *MemoryStore) UpdateGameStartedAt(game *Game, startedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored := s.game(game.ID); stored != nil {
+		stored.LastStartedAt = &startedAt
+	}
+}
+
+func (s *MemoryStore) InsertScores(scores ...*Score) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inserted := make([]*Score, 0, len(scores))
+	for _, sc := range scores {
+		exists := false
+		for _, _sc := range s.scores {
+			if _sc.Game.ID == sc.Game.ID && _sc.Player == sc.Player && _sc.PlayerKey == sc.PlayerKey &&
+				_sc.Task.ID == sc.Task.ID && s.inSession(_sc.Game.ID, _sc.CreatedAt) {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			s.lastID++
+			copied := *sc
+			copied.ID = s.lastID
+			inserted = append(inserted, &copied)
+		}
+	}
+	s.scores = append(s.scores, inserted...)
+}
+
+func (s *MemoryStore) GetTaskScores(game *Game, task *Task) []*Score {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scores := make([]*Score, 0)
+	for _, sc := range s.scores {
+		if sc.Game.ID == game.ID && sc.Task.ID == task.ID && s.inSession(game.ID, sc.CreatedAt) {
+			copied := *sc
+			copied.Game, copied.Task, copied.Question = game, task, task.Question
+			scores = append(scores, &copied)
+		}
+	}
+	return scores
+}
+
+func (s *MemoryStore) UpdateScore(score *Score) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sc := range s.scores {
+		if sc.ID == score.ID {
+			sc.Score = score.Score
+		}
+	}
+}
+
+func (s *MemoryStore) DeliverTask(game *Game, task *Task, player string, playerKey string, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.deliveries {
+		if d.gameID == game.ID && d.taskID == task.ID && d.player == player && d.playerKey == playerKey &&
+			s.inSession(game.ID, d.deliveredAt) {
+			return d.deliveredAt
+		}
+	}
+	s.deliveries = append(s.deliveries, memoryDelivery{game.ID, task.ID, player, playerKey, now})
+	return now
+}
+
+func (s *MemoryStore) GetDeliveries(game *Game, task *Task) []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := make([]Delivery, 0)
+	for _, d := range s.deliveries {
+		if d.gameID == game.ID && d.taskID == task.ID && s.inSession(game.ID, d.deliveredAt) {
+			deliveries = append(deliveries, Delivery{d.player, d.playerKey, d.deliveredAt})
+		}
+	}
+	return deliveries
+}
+
+func (s *MemoryStore) GetScores(game *Game) []TotalScore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	numTasks := len(s.tasks[game.ID])
+	index := make(map[string]int)
+	scores := make([]TotalScore, 0)
+	counts := make([]int, 0)
+	last := make([]time.Time, 0)
+	for _, sc := range s.scores {
+		if sc.Game.ID != game.ID || !s.inSession(game.ID, sc.CreatedAt) {
+			continue
+		}
+		i, ok := index[sc.Player]
+		if !ok {
+			i = len(scores)
+			index[sc.Player] = i
+			scores = append(scores, TotalScore{Player: sc.Player})
+			counts = append(counts, 0)
+			last = append(last, sc.CreatedAt)
+		}
+		scores[i].Score += sc.Score
+		counts[i]++
+		if sc.CreatedAt.After(last[i]) {
+			last[i] = sc.CreatedAt
+		}
+	}
+	order := make([]int, len(scores))
+	for i := range scores {
+		if numTasks > 0 {
+			scores[i].Completed = counts[i] * 100 / numTasks
+		}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if scores[a].Score != scores[b].Score {
+			return scores[a].Score > scores[b].Score
+		}
+		if scores[a].Completed != scores[b].Completed {
+			return scores[a].Completed < scores[b].Completed
+		}
+		return last[a].Before(last[b])
+	})
+	sorted := make([]TotalScore, len(order))
+	for i, j := range order {
+		sorted[i] = scores[j]
+	}
+	return sorted
+}
+
+func (s *MemoryStore) GetPlayerStreak(game *Game, player string, playerKey string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	streak := 0
+	for i := len(s.scores) - 1; i >= 0; i-- {
+		sc := s.scores[i]
+		if sc.Game.ID != game.ID || sc.Player != player || sc.PlayerKey != playerKey ||
+			!s.inSession(game.ID, sc.CreatedAt) {
+			continue
+		}
+		if sc.Score <= 0 {
+			break
+		}
+		streak++
+	}
+	return streak
+}
+
+func (s *MemoryStore) HasPlayerInScores(game *Game, player string, playerKey string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sc := range s.scores {
+		if sc.Game.ID == game.ID && sc.Player == player && sc.PlayerKey != playerKey &&
+			s.inSession(game.ID, sc.CreatedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) AppendEvents(events ...*Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, event := range events {
+		copied := *event
+		s.events = append(s.events, &copied)
+	}
+}
+
+func (s *MemoryStore) GetSessions(game *Game) []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := make([]time.Time, 0)
+	seen := make(map[time.Time]bool)
+	for _, event := range s.events {
+		if event.GameID == game.ID && !seen[event.Session] {
+			seen[event.Session] = true
+			sessions = append(sessions, event.Session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].After(sessions[j])
+	})
+	return sessions
+}
+
+func (s *MemoryStore) GetEvents(game *Game, session time.Time) []*Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]*Event, 0)
+	for _, event := range s.events {
+		if event.GameID == game.ID && event.Session.Equal(session) {
+			copied := *event
+			events = append(events, &copied)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Seq < events[j].Seq
+	})
+	return events
+}
+
+func (s *MemoryStore) question(id int) *Question {
+	for _, q := range s.questions {
+		if q.ID == id {
+			return q
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) InsertQuestions(questions ...*Question) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, question := range questions {
+		s.lastID++
+		question.ID = s.lastID
+		copied := *question
+		s.questions = append(s.questions, &copied)
+	}
+}
+
+func (s *MemoryStore) GetQuestion(id int) *Question {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := s.question(id)
+	if q == nil {
+		return nil
+	}
+	copied := *q
+	return &copied
+}
+
+func (s *MemoryStore) GetQuestions(filter QuestionFilter) []*Question {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	questions := make([]*Question, 0)
+	for _, q := range s.questions {
+		if filter.Matches(q) {
+			copied := *q
+			questions = append(questions, &copied)
+		}
+	}
+	return questions
+}
+
+func (s *MemoryStore) SaveDraws(game *Game, session time.Time, tasks []*Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, task := range tasks {
+		key := memoryDraw{task.ID, session.UnixNano()}
+		if _, ok := s.draws[key]; !ok && task.Draw != nil && task.QuestionID != 0 {
+			s.draws[key] = task.QuestionID
+		}
+	}
+}
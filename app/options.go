@@ -0,0 +1,78 @@
+package app
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	LateJoinAllow    = "allow"
+	LateJoinSpectate = "spectate"
+	LateJoinDeny     = "deny"
+)
+
+const (
+	TeamScoringSum     = "sum"
+	TeamScoringAverage = "average"
+	TeamScoringBest    = "best"
+)
+
+// GameOptions are the per-game settings stored as JSON in games.options.
+type GameOptions struct {
+	Teams       []string       `json:"teams,omitempty"`
+	TeamScoring string         `json:"team_scoring,omitempty"`
+	Scoring     ScoringOptions `json:"scoring"`
+	PowerUps    []string       `json:"power_ups,omitempty"`
+	WoC         WoCOptions     `json:"woc"`
+	MaxPlayers  int            `json:"max_players,omitempty"`
+	LateJoin    string         `json:"late_join,omitempty"`
+	AutoEnd     bool           `json:"auto_end,omitempty"`
+	Shuffle     ShuffleOptions `json:"shuffle"`
+}
+
+func (o *GameOptions) Validate() error {
+	switch o.TeamScoring {
+	case "", TeamScoringSum, TeamScoringAverage, TeamScoringBest:
+	default:
+		return fmt.Errorf("unknown team scoring %q", o.TeamScoring)
+	}
+	switch o.LateJoin {
+	case "", LateJoinAllow, LateJoinSpectate, LateJoinDeny:
+	default:
+		return fmt.Errorf("unknown late join policy %q", o.LateJoin)
+	}
+	if o.MaxPlayers < 0 {
+		return errors.New("max players must not be negative")
+	}
+	for _, powerUp := range o.PowerUps {
+		if powerUp != PowerUpDoubleOrNothing {
+			return fmt.Errorf("unknown power-up %q", powerUp)
+		}
+	}
+	if err := o.WoC.Validate(); err != nil {
+		return err
+	}
+	if err := o.Shuffle.Validate(); err != nil {
+		return err
+	}
+	return o.Scoring.Validate()
+}
+
+func (o *GameOptions) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*o = GameOptions{}
+		return nil
+	case []byte:
+		return json.Unmarshal(src, o)
+	case string:
+		return json.Unmarshal([]byte(src), o)
+	}
+	return fmt.Errorf("cannot scan %T into GameOptions", src)
+}
+
+func (o GameOptions) Value() (driver.Value, error) {
+	return json.Marshal(o)
+}
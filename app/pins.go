@@ -0,0 +1,128 @@
+package app
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	pinDigits = 6
+	pinTTL    = 6 * time.Hour
+)
+
+// A client is refused resolving PINs for pinFailureWindow after pinMaxFailures wrong ones within it,
+// so that the PINs of running sessions cannot be guessed by trying them all.
+const (
+	pinMaxFailures   = 10
+	pinFailureWindow = time.Minute
+)
+
+var (
+	ErrPinUnknown   = errors.New("there is no game with the PIN")
+	ErrPinThrottled = errors.New("too many wrong PINs")
+)
+
+type pinEntry struct {
+	gameID    int
+	expiresAt time.Time
+}
+
+type pinFailures struct {
+	count int
+	since time.Time
+}
+
+// PinRegistry issues short numeric PINs to join live sessions, as hashids are painful to type from
+// a projector. A PIN lives as long as its session, but not longer than pinTTL.
+type PinRegistry struct {
+	pins     map[string]pinEntry
+	failures map[string]*pinFailures
+	swept    time.Time
+	// clock, if set, tells the time instead of DefaultClock
+	clock Clock
+	mu    sync.Mutex
+}
+
+func (r *PinRegistry) now() time.Time {
+	if r.clock != nil {
+		return r.clock.Now()
+	}
+	return DefaultClock.Now()
+}
+
+func (r *PinRegistry) Issue(gameID int) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for pin, entry := range r.pins {
+		if now.After(entry.expiresAt) {
+			delete(r.pins, pin)
+		}
+	}
+
+	max := big.NewInt(1)
+	for i := 0; i < pinDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	for {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		pin := fmt.Sprintf("%0*d", pinDigits, n)
+		if _, ok := r.pins[pin]; !ok {
+			r.pins[pin] = pinEntry{gameID: gameID, expiresAt: now.Add(pinTTL)}
+			return pin
+		}
+	}
+}
+
+// Resolve returns the ID of the game the PIN was issued for. The client, e.g. the IP address of the
+// request, is throttled if it keeps trying wrong PINs.
+func (r *PinRegistry) Resolve(pin string, client string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.swept) >= pinFailureWindow {
+		for key, failures := range r.failures {
+			if now.Sub(failures.since) >= pinFailureWindow {
+				delete(r.failures, key)
+			}
+		}
+		r.swept = now
+	}
+
+	failures := r.failures[client]
+	if failures != nil && now.Sub(failures.since) >= pinFailureWindow {
+		failures = nil
+	}
+	if failures != nil && failures.count >= pinMaxFailures {
+		return 0, ErrPinThrottled
+	}
+
+	entry, ok := r.pins[pin]
+	if !ok || now.After(entry.expiresAt) {
+		if failures == nil {
+			failures = &pinFailures{since: now}
+			r.failures[client] = failures
+		}
+		failures.count++
+		return 0, ErrPinUnknown
+	}
+	return entry.gameID, nil
+}
+
+func (r *PinRegistry) Release(pin string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.pins, pin)
+}
+
+var JoinPins = &PinRegistry{pins: make(map[string]pinEntry), failures: make(map[string]*pinFailures)}
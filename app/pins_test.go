@@ -0,0 +1,75 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestPinRegistry() (*PinRegistry, *FakeClock) {
+	clock := NewFakeClock(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+	return &PinRegistry{pins: make(map[string]pinEntry), failures: make(map[string]*pinFailures), clock: clock}, clock
+}
+
+func TestPinRegistryResolve(t *testing.T) {
+	r, _ := newTestPinRegistry()
+	pin := r.Issue(42)
+	if len(pin) != pinDigits {
+		t.Fatalf("got PIN %q", pin)
+	}
+	if id, err := r.Resolve(pin, "a"); err != nil || id != 42 {
+		t.Fatalf("got %d, %v", id, err)
+	}
+
+	wrong := "x"
+	for i := 0; i < pinMaxFailures; i++ {
+		if _, err := r.Resolve(wrong, "a"); err != ErrPinUnknown {
+			t.Fatalf("attempt %d: got %v", i+1, err)
+		}
+	}
+	if _, err := r.Resolve(pin, "a"); err != ErrPinThrottled {
+		t.Errorf("got %v after %d wrong PINs", err, pinMaxFailures)
+	}
+	if id, err := r.Resolve(pin, "b"); err != nil || id != 42 {
+		t.Errorf("other client: got %d, %v", id, err)
+	}
+
+	r.Release(pin)
+	if _, err := r.Resolve(pin, "b"); err != ErrPinUnknown {
+		t.Errorf("released PIN: got %v", err)
+	}
+}
+
+func TestPinRegistryThrottleWindow(t *testing.T) {
+	r, clock := newTestPinRegistry()
+	pin := r.Issue(42)
+	for i := 0; i < pinMaxFailures; i++ {
+		r.Resolve("x", "a")
+	}
+
+	clock.Advance(pinFailureWindow - time.Second)
+	if _, err := r.Resolve(pin, "a"); err != ErrPinThrottled {
+		t.Errorf("within the window: got %v", err)
+	}
+	clock.Advance(time.Second)
+	if id, err := r.Resolve(pin, "a"); err != nil || id != 42 {
+		t.Errorf("after the window: got %d, %v", id, err)
+	}
+}
+
+func TestPinRegistryExpiry(t *testing.T) {
+	r, clock := newTestPinRegistry()
+	pin := r.Issue(42)
+
+	clock.Advance(pinTTL)
+	if id, err := r.Resolve(pin, "a"); err != nil || id != 42 {
+		t.Errorf("at the end of its life: got %d, %v", id, err)
+	}
+	clock.Advance(time.Second)
+	if _, err := r.Resolve(pin, "a"); err != ErrPinUnknown {
+		t.Errorf("expired PIN: got %v", err)
+	}
+	r.Issue(43)
+	if entry, ok := r.pins[pin]; ok && entry.gameID == 42 {
+		t.Error("expired PIN is kept after issuing another")
+	}
+}
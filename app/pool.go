@@ -1,19 +1,38 @@
 package app
 
 import (
+	"log"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/lokhman/kakadoo/protocol"
 )
 
 type Player struct {
-	Game     *Game  `json:"-"`
-	Name     string `json:"name"`
-	IsAuthor bool   `json:"is_author"`
+	Game        *Game  `json:"-"`
+	Name        string `json:"name"`
+	Team        string `json:"team,omitempty"`
+	IsAuthor    bool   `json:"is_author"`
+	IsBaseline  bool   `json:"is_baseline,omitempty"`
+	IsSpectator bool   `json:"is_spectator,omitempty"`
 
 	ws       *websocket.Conn
-	send     chan *wireMessage
+	send     chan *protocol.Message
+	version  int
 	gameplay *gameplay
+	// joined hands the gameplay over to the reader of the player once Run has let the player in
+	joined chan *gameplay
+}
+
+func (p *Player) data() protocol.Player {
+	return protocol.Player{
+		Name:        p.Name,
+		Team:        p.Team,
+		IsAuthor:    p.IsAuthor,
+		IsBaseline:  p.IsBaseline,
+		IsSpectator: p.IsSpectator,
+	}
 }
 
 func (p *Player) closeWithDelay() {
@@ -23,14 +42,26 @@ func (p *Player) closeWithDelay() {
 
 type broadcastMessage struct {
 	Game    *Game
-	Message *wireMessage
+	Message *protocol.Message
+	To      func(*Player) bool
+	// Each, if set, makes the message for every player instead of sending Message to all.
+	Each func(*Player) *protocol.Message
+}
+
+type openRequest struct {
+	Game     *Game
+	gameplay chan *gameplay
 }
 
 type Pool struct {
 	players    map[*Player]struct{}
+	gameplays  map[int]*gameplay
+	waiting    map[int][]*Player
 	register   chan *Player
 	unregister chan *Player
 	broadcast  chan *broadcastMessage
+	open       chan *openRequest
+	clock      Clock
 }
 
 func (p *Pool) getPlayers(game *Game) []*Player {
@@ -43,81 +74,188 @@ func (p *Pool) getPlayers(game *Game) []*Player {
 	return players
 }
 
-func (p *Pool) Run() {
-	for {
-		select {
-		case player := <-p.register:
-			var gp *gameplay
+func (p *Pool) getGameplay(game *Game, create bool) *gameplay {
+	gp := p.gameplays[game.ID]
+	if gp != nil && gp.State() == gpsFinished {
+		gp = nil
+	}
+	if gp == nil && create {
+		gp = newGameplay(game, p.clock)
+		gp.pin = JoinPins.Issue(game.ID)
+		gp.events = newEventLog(game.ID, p.clock.Now())
+		gp.initBaselines()
+		p.gameplays[game.ID] = gp
+	}
+	return gp
+}
 
-			players := p.getPlayers(player.Game)
-			for _, _player := range players {
-				if gp == nil && _player.gameplay != nil && _player.gameplay.state != gpsFinished {
-					gp = _player.gameplay
-				}
-				if _player.Name == player.Name {
-					player.send <- &wireMessage{Type: wmtPlayerExists}
-					go player.closeWithDelay()
-					goto _continue
-				}
+// Open returns the gameplay of the game, creating one if needed, so that players can join it
+// without its author.
+func (p *Pool) Open(game *Game) *gameplay {
+	req := &openRequest{
+		Game:     game,
+		gameplay: make(chan *gameplay),
+	}
+	p.open <- req
+	return <-req.gameplay
+}
+
+// join adds the player to the gameplay, unless the game is full or has started and does not let
+// players join late. Spectators, such as presenter screens, can always join.
+func (p *Pool) join(player *Player, gp *gameplay) {
+	if !player.IsAuthor && !player.IsSpectator {
+		if gp.State() != gpsReady {
+			switch gp.lateJoin {
+			case LateJoinDeny:
+				p.reject(player, protocol.GameInProgress)
+				return
+			case LateJoinSpectate:
+				player.IsSpectator = true
 			}
+		}
+		if !player.IsSpectator && gp.maxPlayers > 0 && gp.NumPlayers() >= gp.maxPlayers {
+			p.reject(player, protocol.GameFull)
+			return
+		}
+	}
 
-			if gp == nil {
-				if player.IsAuthor {
-					gp = newGameplay(player.Game)
-					if gp.gameType == GameTypeWoC {
-						gp.Init(&Player{Name: wocPlayerMean})
-						gp.Init(&Player{Name: wocPlayerMedian})
-					}
-				} else {
-					player.send <- &wireMessage{Type: wmtNotReady}
-					go player.closeWithDelay()
-					goto _continue
+	player.gameplay = gp
+	player.joined <- gp
+	if !player.IsSpectator {
+		player.Team = gp.AssignTeam(player.Team)
+
+		for _, _player := range p.getPlayers(player.Game) {
+			if _player != player && _player.gameplay != nil {
+				_player.send <- &protocol.Message{
+					Type: protocol.PlayerRegistered,
+					Data: player.data(),
 				}
 			}
+		}
 
-			for _, _player := range players {
-				_player.send <- &wireMessage{
-					Type: wmtPlayerRegistered,
-					Data: player,
+		player.gameplay.Init(player)
+		gp.events.record(protocol.PlayerRegistered, player.Name, player.data(), p.clock.Now())
+	}
+
+	players := gp.GetPlayers()
+	data := protocol.ReadyData{
+		Version:     player.version,
+		Name:        player.Name,
+		IsSpectator: player.IsSpectator,
+		Players:     make([]protocol.Player, len(players)),
+		Ready:       gp.GetReadyPlayers(),
+		Type:        gp.gameType,
+		State:       int(gp.State()),
+		NumTasks:    gp.NumTasks(),
+		StartsAt:    gp.StartsAt(),
+		Teams:       gp.teams,
+		PowerUps:    gp.powerUps,
+		MaxPlayers:  gp.maxPlayers,
+		Pin:         gp.pin,
+	}
+	for i, _player := range players {
+		data.Players[i] = _player.data()
+	}
+	player.send <- &protocol.Message{
+		Type: protocol.Ready,
+		Data: data,
+	}
+
+	p.players[player] = struct{}{}
+}
+
+// admit lets the players waiting for the game to open join the gameplay in the order they came.
+func (p *Pool) admit(game *Game, gp *gameplay) {
+	for _, player := range p.waiting[game.ID] {
+		p.join(player, gp)
+	}
+	delete(p.waiting, game.ID)
+}
+
+func (p *Pool) reject(player *Player, reason protocol.MessageType) {
+	delete(p.players, player)
+	player.send <- &protocol.Message{Type: reason}
+	go player.closeWithDelay()
+}
+
+func (p *Pool) Run() {
+	for {
+		select {
+		case player := <-p.register:
+			for _, _player := range p.getPlayers(player.Game) {
+				if _player.Name == player.Name && !player.IsSpectator {
+					player.send <- &protocol.Message{Type: protocol.PlayerExists}
+					go player.closeWithDelay()
+					goto _continue
 				}
 			}
 
-			player.gameplay = gp
-			player.gameplay.Init(player)
-
-			player.send <- &wireMessage{
-				Type: wmtReady,
-				Data: map[string]interface{}{
-					"name":         player.Name,
-					"players":      gp.GetPlayers(),
-					"gp_type":      gp.gameType,
-					"gp_state":     gp.state,
-					"gp_num_tasks": len(gp.tasks),
-				},
+			if gp := p.getGameplay(player.Game, player.IsAuthor); gp != nil {
+				p.join(player, gp)
+				p.admit(player.Game, gp)
+			} else {
+				// the player waits in the lobby until the author opens the game
+				p.players[player] = struct{}{}
+				p.waiting[player.Game.ID] = append(p.waiting[player.Game.ID], player)
+				player.send <- &protocol.Message{Type: protocol.NotReady}
 			}
-
-			p.players[player] = struct{}{}
 		case player := <-p.unregister:
 			if _, ok := p.players[player]; ok {
 				delete(p.players, player)
 				close(player.send)
 
-				delete(player.gameplay.scores, player)
+				waiting := p.waiting[player.Game.ID]
+				for i, _player := range waiting {
+					if _player == player {
+						p.waiting[player.Game.ID] = append(waiting[:i], waiting[i+1:]...)
+						break
+					}
+				}
+				if player.gameplay == nil {
+					break
+				}
+
+				player.gameplay.Leave(player)
 
 				players := p.getPlayers(player.Game)
-				for _, _player := range players {
-					_player.send <- &wireMessage{
-						Type: wmtPlayerUnregistered,
-						Data: player,
+				if !player.IsSpectator {
+					player.gameplay.events.record(protocol.PlayerUnregistered, player.Name, nil, p.clock.Now())
+					for _, _player := range players {
+						if _player.gameplay != nil {
+							_player.send <- &protocol.Message{
+								Type: protocol.PlayerUnregistered,
+								Data: player.data(),
+							}
+						}
+					}
+				}
+				if gp := p.gameplays[player.Game.ID]; len(players) == 0 && gp == player.gameplay {
+					if !gp.IsScheduled() || gp.State() == gpsFinished {
+						JoinPins.Release(gp.pin)
+						gp.events.close()
+						delete(p.gameplays, player.Game.ID)
 					}
 				}
 			}
+		case req := <-p.open:
+			gp := p.getGameplay(req.Game, true)
+			gp.Schedule(*req.Game.ScheduledAt)
+			p.admit(req.Game, gp)
+			req.gameplay <- gp
 		case bm := <-p.broadcast:
 			players := p.getPlayers(bm.Game)
 			for _, player := range players {
+				if player.gameplay == nil || (bm.To != nil && !bm.To(player)) {
+					continue
+				}
+				message := bm.Message
+				if bm.Each != nil {
+					message = bm.Each(player)
+				}
 				select {
-				case player.send <- bm.Message:
+				case player.send <- message:
 				default:
+					log.Printf("dropping %q from game %d: too slow to receive", player.Name, bm.Game.ID)
 					delete(p.players, player)
 					close(player.send)
 				}
@@ -130,8 +268,12 @@ func (p *Pool) Run() {
 func NewPool() *Pool {
 	return &Pool{
 		players:    make(map[*Player]struct{}),
+		gameplays:  make(map[int]*gameplay),
+		waiting:    make(map[int][]*Player),
 		register:   make(chan *Player),
 		unregister: make(chan *Player),
 		broadcast:  make(chan *broadcastMessage),
+		open:       make(chan *openRequest),
+		clock:      DefaultClock,
 	}
 }
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/lokhman/kakadoo/protocol"
+)
+
+const (
+	RegionRect    = "rect"
+	RegionCircle  = "circle"
+	RegionPolygon = "poly"
+)
+
+// regionFalloff is a pseudo-region that sets the distance (in image pixels) over which
+// the score of a miss decays from 1 to 0. Without it every miss scores 0.
+const regionFalloff = "falloff"
+
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+func (p Point) distanceTo(o Point) float64 {
+	return math.Hypot(p.X-o.X, p.Y-o.Y)
+}
+
+func (p Point) data() protocol.Point {
+	return protocol.Point(p)
+}
+
+func ParsePoint(s string) (Point, error) {
+	values, err := parseFloats(s)
+	if err != nil {
+		return Point{}, err
+	}
+	if len(values) != 2 {
+		return Point{}, fmt.Errorf("point must have 2 coordinates, got %d", len(values))
+	}
+	return Point{values[0], values[1]}, nil
+}
+
+type Region struct {
+	Type   string  `json:"type"`
+	Points []Point `json:"points"`
+	Radius float64 `json:"radius,omitempty"`
+}
+
+func (r *Region) data() protocol.Region {
+	points := make([]protocol.Point, len(r.Points))
+	for i, p := range r.Points {
+		points[i] = p.data()
+	}
+	return protocol.Region{Type: r.Type, Points: points, Radius: r.Radius}
+}
+
+func (r *Region) contains(p Point) bool {
+	switch r.Type {
+	case RegionRect:
+		return p.X >= r.Points[0].X && p.X <= r.Points[1].X && p.Y >= r.Points[0].Y && p.Y <= r.Points[1].Y
+	case RegionCircle:
+		return p.distanceTo(r.Points[0]) <= r.Radius
+	case RegionPolygon:
+		in := false
+		for i, j := 0, len(r.Points)-1; i < len(r.Points); j, i = i, i+1 {
+			a, b := r.Points[i], r.Points[j]
+			if (a.Y > p.Y) != (b.Y > p.Y) && p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+				in = !in
+			}
+		}
+		return in
+	}
+	return false
+}
+
+func (r *Region) Distance(p Point) float64 {
+	if r.contains(p) {
+		return 0
+	}
+	switch r.Type {
+	case RegionRect:
+		dx := math.Max(math.Max(r.Points[0].X-p.X, 0), p.X-r.Points[1].X)
+		dy := math.Max(math.Max(r.Points[0].Y-p.Y, 0), p.Y-r.Points[1].Y)
+		return math.Hypot(dx, dy)
+	case RegionCircle:
+		return p.distanceTo(r.Points[0]) - r.Radius
+	case RegionPolygon:
+		distance := math.Inf(1)
+		for i, j := 0, len(r.Points)-1; i < len(r.Points); j, i = i, i+1 {
+			distance = math.Min(distance, segmentDistance(p, r.Points[j], r.Points[i]))
+		}
+		return distance
+	}
+	return math.Inf(1)
+}
+
+func segmentDistance(p, a, b Point) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	if dx == 0 && dy == 0 {
+		return p.distanceTo(a)
+	}
+	t := ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return p.distanceTo(Point{a.X + t*dx, a.Y + t*dy})
+}
+
+type Regions struct {
+	Regions []*Region `json:"regions"`
+	Falloff float64   `json:"falloff,omitempty"`
+}
+
+// ParseRegions parses a region definition stored in Task.CorrectAnswer. Definitions are separated
+// by semicolons, e.g. "rect:10,10,50,40; circle:120,80,25; poly:0,0,30,0,15,20; falloff:40".
+// A bare "x1,y1,x2,y2" is read as a rectangle for compatibility with older tasks.
+func ParseRegions(s string) (*Regions, error) {
+	regions := &Regions{}
+	for _, def := range strings.Split(s, ";") {
+		if def = strings.TrimSpace(def); def == "" {
+			continue
+		}
+
+		kind := RegionRect
+		if i := strings.IndexByte(def, ':'); i != -1 {
+			kind, def = strings.TrimSpace(def[:i]), def[i+1:]
+		}
+		values, err := parseFloats(def)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", kind, err)
+		}
+
+		region := &Region{Type: kind}
+		switch kind {
+		case RegionRect:
+			if len(values) != 4 {
+				return nil, fmt.Errorf("rect: expected 4 values, got %d", len(values))
+			}
+			x1, y1 := math.Min(values[0], values[2]), math.Min(values[1], values[3])
+			x2, y2 := math.Max(values[0], values[2]), math.Max(values[1], values[3])
+			region.Points = []Point{{x1, y1}, {x2, y2}}
+		case RegionCircle:
+			if len(values) != 3 {
+				return nil, fmt.Errorf("circle: expected 3 values, got %d", len(values))
+			}
+			if values[2] <= 0 {
+				return nil, errors.New("circle: radius must be positive")
+			}
+			region.Points = []Point{{values[0], values[1]}}
+			region.Radius = values[2]
+		case RegionPolygon:
+			if len(values) < 6 || len(values)%2 != 0 {
+				return nil, errors.New("poly: expected at least 3 points")
+			}
+			for i := 0; i < len(values); i += 2 {
+				region.Points = append(region.Points, Point{values[i], values[i+1]})
+			}
+		case regionFalloff:
+			if len(values) != 1 || values[0] < 0 {
+				return nil, errors.New("falloff: expected a single non-negative value")
+			}
+			regions.Falloff = values[0]
+			continue
+		default:
+			return nil, fmt.Errorf("unknown region type %q", kind)
+		}
+		regions.Regions = append(regions.Regions, region)
+	}
+	if len(regions.Regions) == 0 {
+		return nil, errors.New("no regions defined")
+	}
+	return regions, nil
+}
+
+// Score returns 1 for a hit inside any of the regions. A miss scores linearly less the further
+// it is from the nearest region, reaching 0 at the falloff distance.
+func (r *Regions) Score(p Point) float64 {
+	distance := math.Inf(1)
+	for _, region := range r.Regions {
+		distance = math.Min(distance, region.Distance(p))
+	}
+	if distance == 0 {
+		return 1
+	}
+	if r.Falloff == 0 || distance >= r.Falloff {
+		return 0
+	}
+	return 1 - distance/r.Falloff
+}
+
+func (r *Regions) ScoreAnswer(answer string) float64 {
+	p, err := ParsePoint(answer)
+	if err != nil {
+		return 0
+	}
+	return r.Score(p)
+}
+
+func parseFloats(s string) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	values := make([]float64, len(parts))
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("invalid number %q", strings.TrimSpace(part))
+		}
+		values[i] = value
+	}
+	return values, nil
+}
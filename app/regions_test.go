@@ -0,0 +1,97 @@
+package app
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseRegions(t *testing.T) {
+	tests := []struct {
+		def     string
+		regions []Region
+		falloff float64
+		err     string
+	}{
+		{def: "rect:10,10,50,40", regions: []Region{{Type: RegionRect, Points: []Point{{10, 10}, {50, 40}}}}},
+		{def: "50,40,10,10", regions: []Region{{Type: RegionRect, Points: []Point{{10, 10}, {50, 40}}}}},
+		{def: "circle:120,80,25", regions: []Region{{Type: RegionCircle, Points: []Point{{120, 80}}, Radius: 25}}},
+		{def: "poly:0,0,30,0,15,20; falloff:40",
+			regions: []Region{{Type: RegionPolygon, Points: []Point{{0, 0}, {30, 0}, {15, 20}}}}, falloff: 40},
+		{def: " rect:0,0,1,1 ;; circle:5,5,1 ", regions: []Region{
+			{Type: RegionRect, Points: []Point{{0, 0}, {1, 1}}},
+			{Type: RegionCircle, Points: []Point{{5, 5}}, Radius: 1},
+		}},
+		{def: "", err: "no regions defined"},
+		{def: "falloff:10", err: "no regions defined"},
+		{def: "rect:1,2,3", err: "rect: expected 4 values, got 3"},
+		{def: "circle:1,2,0", err: "circle: radius must be positive"},
+		{def: "poly:0,0,1,1", err: "poly: expected at least 3 points"},
+		{def: "falloff:-1; rect:0,0,1,1", err: "falloff: expected a single non-negative value"},
+		{def: "star:1,2", err: `unknown region type "star"`},
+		{def: "rect:a,b,c,d", err: "rect: "},
+	}
+	for _, tt := range tests {
+		regions, err := ParseRegions(tt.def)
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("%q: got error %v, want %q", tt.def, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.def, err)
+			continue
+		}
+		if len(regions.Regions) != len(tt.regions) || regions.Falloff != tt.falloff {
+			t.Errorf("%q: got %+v", tt.def, regions)
+			continue
+		}
+		for i, want := range tt.regions {
+			got := regions.Regions[i]
+			if got.Type != want.Type || got.Radius != want.Radius || len(got.Points) != len(want.Points) {
+				t.Errorf("%q: got region %+v, want %+v", tt.def, got, want)
+				continue
+			}
+			for j := range want.Points {
+				if got.Points[j] != want.Points[j] {
+					t.Errorf("%q: got region %+v, want %+v", tt.def, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRegionsScoreAnswer(t *testing.T) {
+	tests := []struct {
+		def    string
+		answer string
+		score  float64
+	}{
+		{"rect:10,10,50,40", "10,10", 1},
+		{"rect:10,10,50,40", "30,25", 1},
+		{"rect:10,10,50,40", "60,25", 0},
+		{"10,10,50,40", "30,25", 1},
+		{"rect:10,10,50,40; falloff:20", "60,25", 0.5},
+		{"rect:10,10,50,40; falloff:20", "53,44", 0.75},
+		{"rect:10,10,50,40; falloff:20", "80,25", 0},
+		{"circle:0,0,10", "6,8", 1},
+		{"circle:0,0,10; falloff:10", "0,15", 0.5},
+		{"poly:0,0,30,0,15,20", "15,5", 1},
+		{"poly:0,0,30,0,15,20", "15,25", 0},
+		{"poly:0,0,30,0,15,20; falloff:10", "15,-5", 0.5},
+		{"rect:0,0,1,1; circle:100,100,10", "100,105", 1},
+		{"rect:0,0,10,10; circle:100,100,10; falloff:20", "15,5", 0.75},
+		{"rect:0,0,10,10", "not a point", 0},
+		{"rect:0,0,10,10", "1,2,3", 0},
+	}
+	for _, tt := range tests {
+		regions, err := ParseRegions(tt.def)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.def, err)
+		}
+		if score := regions.ScoreAnswer(tt.answer); math.Abs(score-tt.score) > 1e-9 {
+			t.Errorf("%q at %q: got %v, want %v", tt.def, tt.answer, score, tt.score)
+		}
+	}
+}
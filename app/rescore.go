@@ -0,0 +1,311 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lokhman/kakadoo/protocol"
+)
+
+// ScoreChange is a stored score that rescoring changes from Old to Score.Score.
+type ScoreChange struct {
+	Score *Score
+	Old   float64
+}
+
+// LeaderboardChange is the total score and the place of a player in the session before and after
+// rescoring.
+type LeaderboardChange struct {
+	Player      string
+	Before      float64
+	After       float64
+	BeforePlace int
+	AfterPlace  int
+}
+
+func (c LeaderboardChange) Changed() bool {
+	return c.Before != c.After || c.BeforePlace != c.AfterPlace
+}
+
+// Rescore holds the scores of a session recomputed with the current tasks of the game, to be
+// reviewed before they are applied.
+type Rescore struct {
+	Game        *Game
+	Changes     []ScoreChange
+	Leaderboard []LeaderboardChange
+}
+
+type rescorePlayer struct {
+	name string
+	key  string
+}
+
+// rescoreEpoch is where the tasks of a rescored session start, as only the time taken to answer
+// matters.
+var rescoreEpoch = time.Unix(0, 0).UTC()
+
+// RescoreGame scores the answers stored for the current session of the game again, e.g. after the
+// correct answer of a task was fixed. The tasks are scored in order by the rules of calculateScores,
+// so that streaks and the ranks of woc estimates follow the corrected answers, except for self-paced
+// quizzes, which are scored in the order every player went through the tasks. The time taken to
+// answer comes from the deliveries of self-paced games and from the event log of live ones, which
+// also tells the power-ups armed.
+func RescoreGame(game *Game) (*Rescore, error) {
+	if game.LastStartedAt == nil {
+		return nil, errors.New("the game has not been played")
+	}
+
+	gp := newGameplayWithTasks(game, SessionTasks(game), DefaultClock)
+	var starts map[int]time.Time
+	var armed map[int]map[string]string
+	if game.IsLive() {
+		var err error
+		if starts, armed, err = sessionTimeline(game); err != nil {
+			return nil, err
+		}
+	}
+
+	scores := make([][]*Score, len(gp.tasks))
+	players := make(map[rescorePlayer]*Player)
+	for i, task := range gp.tasks {
+		scores[i] = GetTaskScores(game, task)
+		for _, sc := range scores[i] {
+			key := rescorePlayer{sc.Player, sc.PlayerKey}
+			if players[key] == nil {
+				players[key] = &Player{Game: game, Name: sc.Player}
+				gp.Init(players[key])
+			}
+		}
+	}
+
+	elapsed := make([][]time.Duration, len(gp.tasks))
+	for i, task := range gp.tasks {
+		var err error
+		if elapsed[i], err = answerTimes(game, task, i, scores[i], starts); err != nil {
+			return nil, fmt.Errorf("task %d: %v", i+1, err)
+		}
+		for j := range elapsed[i] {
+			if elapsed[i][j] > task.timeToAnswer() {
+				elapsed[i][j] = task.timeToAnswer()
+			}
+		}
+	}
+
+	switch {
+	case game.Type == GameTypeFindCat && !game.IsLive():
+		// self-paced find_cat games score the hit alone
+		for i, task := range gp.tasks {
+			regions, err := ParseRegions(task.CorrectAnswer)
+			if err != nil {
+				return nil, fmt.Errorf("task %d: %v", i+1, err)
+			}
+			for _, sc := range scores[i] {
+				gp.scores[players[rescorePlayer{sc.Player, sc.PlayerKey}]][i] = regions.ScoreAnswer(sc.Answer)
+			}
+		}
+	case game.Type == GameTypeQuiz && !game.IsLive():
+		rescoreSelfPaced(game, gp, players, scores, elapsed)
+	default:
+		for i, task := range gp.tasks {
+			gp.currentTaskIndex = i
+			gp.deadline = rescoreEpoch.Add(task.timeToAnswer())
+			gp.answers[i] = make(map[*Player]gpAnswer, len(scores[i]))
+			for j, sc := range scores[i] {
+				gp.answers[i][players[rescorePlayer{sc.Player, sc.PlayerKey}]] = gpAnswer{
+					answer: sc.Answer,
+					time:   rescoreEpoch.Add(elapsed[i][j]),
+				}
+			}
+			for name, powerUp := range armed[i] {
+				if player := players[rescorePlayer{name: name}]; player != nil {
+					gp.armed[player] = powerUp
+				}
+			}
+			gp.calculateScores(task)
+		}
+	}
+
+	r := &Rescore{Game: game}
+	for i := range gp.tasks {
+		for _, sc := range scores[i] {
+			score := gp.scores[players[rescorePlayer{sc.Player, sc.PlayerKey}]][i]
+			if score != sc.Score {
+				r.Changes = append(r.Changes, ScoreChange{Score: sc, Old: sc.Score})
+				sc.Score = score
+			}
+		}
+	}
+	r.Leaderboard = r.leaderboard(GetScores(game))
+	return r, nil
+}
+
+// rescoreSelfPaced scores the answers to a self-paced quiz as they were played: every player goes
+// through the tasks in the order of the player, which the streaks follow.
+func rescoreSelfPaced(game *Game, gp *gameplay, players map[rescorePlayer]*Player, scores [][]*Score,
+	elapsed [][]time.Duration) {
+	indexes := make(map[int]int, len(gp.tasks))
+	for i, task := range gp.tasks {
+		indexes[task.ID] = i
+	}
+	answered := make(map[rescorePlayer]map[int]int)
+	for i := range gp.tasks {
+		for j, sc := range scores[i] {
+			key := rescorePlayer{sc.Player, sc.PlayerKey}
+			if answered[key] == nil {
+				answered[key] = make(map[int]int)
+			}
+			answered[key][i] = j
+		}
+	}
+
+	for key, player := range players {
+		streak := 0
+		for _, task := range playerOrder(game, gp.tasks, key.name, key.key) {
+			i := indexes[task.ID]
+			j, ok := answered[key][i]
+			if !ok {
+				continue
+			}
+			remaining := task.timeToAnswer() - elapsed[i][j]
+			score := scoreAnswer(gp.scoring, game.Type, task, scores[i][j].Answer, remaining, streak)
+			if score > 0 {
+				streak++
+			} else {
+				streak = 0
+			}
+			gp.scores[player][i] = score
+		}
+	}
+}
+
+// sessionTimeline finds the start of every task and the power-ups armed for it in the event log
+// of the current session of a live game.
+func sessionTimeline(game *Game) (map[int]time.Time, map[int]map[string]string, error) {
+	for _, session := range GetSessions(game) {
+		if session.After(*game.LastStartedAt) {
+			continue
+		}
+		events := GetEvents(game, session)
+		started := false
+		for _, event := range events {
+			if event.Type == protocol.GameStarted && event.CreatedAt.Equal(*game.LastStartedAt) {
+				started = true
+				break
+			}
+		}
+		if !started {
+			continue
+		}
+
+		starts := make(map[int]time.Time)
+		armed := make(map[int]map[string]string)
+		pending := make(map[string]string)
+		index := -1
+		for _, event := range events {
+			switch event.Type {
+			case protocol.Task:
+				var data protocol.TaskData
+				if err := json.Unmarshal(event.Data, &data); err != nil {
+					return nil, nil, fmt.Errorf("event %d: %v", event.Seq, err)
+				}
+				index = data.Index
+				starts[index] = event.CreatedAt
+			case protocol.PowerUp:
+				var powerUp string
+				if err := json.Unmarshal(event.Data, &powerUp); err != nil {
+					return nil, nil, fmt.Errorf("event %d: %v", event.Seq, err)
+				}
+				pending[event.Player] = powerUp
+			case protocol.TaskFinished:
+				// power-ups apply to the task that finishes next
+				armed[index] = pending
+				pending = make(map[string]string)
+			}
+		}
+		return starts, armed, nil
+	}
+	return nil, nil, errors.New("the session has no event log")
+}
+
+// answerTimes returns how long it took to give each of the answers to the task.
+func answerTimes(game *Game, task *Task, index int, scores []*Score, starts map[int]time.Time) ([]time.Duration, error) {
+	elapsed := make([]time.Duration, len(scores))
+	if len(scores) == 0 {
+		return elapsed, nil
+	}
+	if game.IsLive() {
+		start, ok := starts[index]
+		if !ok {
+			return nil, errors.New("the task is missing from the event log")
+		}
+		for i, sc := range scores {
+			elapsed[i] = sc.CreatedAt.Sub(start)
+		}
+		return elapsed, nil
+	}
+	if game.Type == GameTypeFindCat {
+		return elapsed, nil
+	}
+
+	deliveries := make(map[rescorePlayer]time.Time)
+	for _, d := range GetDeliveries(game, task) {
+		deliveries[rescorePlayer{d.Player, d.PlayerKey}] = d.DeliveredAt
+	}
+	for i, sc := range scores {
+		deliveredAt, ok := deliveries[rescorePlayer{sc.Player, sc.PlayerKey}]
+		if !ok {
+			return nil, fmt.Errorf("task was never delivered to %s", sc.Player)
+		}
+		elapsed[i] = sc.CreatedAt.Sub(deliveredAt)
+	}
+	return elapsed, nil
+}
+
+// leaderboard compares the totals of the session with the totals after the changes.
+func (r *Rescore) leaderboard(before []TotalScore) []LeaderboardChange {
+	deltas := make(map[string]float64)
+	for _, change := range r.Changes {
+		deltas[change.Score.Player] += change.Score.Score - change.Old
+	}
+
+	changes := make([]LeaderboardChange, len(before))
+	for i, score := range before {
+		changes[i] = LeaderboardChange{
+			Player:      score.Player,
+			Before:      score.Score,
+			After:       score.Score + deltas[score.Player],
+			BeforePlace: i + 1,
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].After > changes[j].After
+	})
+	for i := range changes {
+		changes[i].AfterPlace = i + 1
+	}
+	return changes
+}
+
+// Digest identifies the changes, so that they are applied only if they are still the ones reviewed.
+func (r *Rescore) Digest() string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%d\x00", r.Game.ID)
+	for _, change := range r.Changes {
+		_, _ = fmt.Fprintf(h, "%d\x00%s\x00%s\x00", change.Score.ID,
+			strconv.FormatFloat(change.Old, 'g', -1, 64), strconv.FormatFloat(change.Score.Score, 'g', -1, 64))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Apply stores the changed scores.
+func (r *Rescore) Apply() {
+	for _, change := range r.Changes {
+		UpdateScore(change.Score)
+	}
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRescoreSelfPacedInPlayerOrder(t *testing.T) {
+	store := NewMemoryStore()
+	prev := DefaultStore
+	DefaultStore = store
+	t.Cleanup(func() { DefaultStore = prev })
+
+	tasks := make([]*Task, 6)
+	for i := range tasks {
+		tasks[i] = &Task{Question: fmt.Sprint(i + 1), Answers: []string{"a", "b"}, CorrectAnswer: "a", TimeToAnswer: 10}
+	}
+	game := store.AddGame(&Game{Type: GameTypeQuiz, Mode: GameModeSelfPaced, Options: GameOptions{
+		Scoring: ScoringOptions{Strategy: ScoringFlat, StreakBonus: 0.5},
+		Shuffle: ShuffleOptions{Tasks: ShufflePlayer},
+	}}, tasks...)
+	startedAt := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	UpdateGameStartedAt(game, startedAt)
+	game = GetGame(game.ID)
+
+	// the players answer in their own order and are scored as the self-paced handler does
+	now := startedAt
+	for _, player := range []string{"alice", "bob"} {
+		for i, task := range playerOrder(game, SessionTasks(game), player, "") {
+			answer := "a"
+			if i == 2 {
+				answer = "b"
+			}
+			deadline := DeliverTask(game, task, player, "", now).Add(task.timeToAnswer())
+			now = now.Add(time.Second)
+			score := scoreAnswer(newScoringStrategy(game.Options.Scoring), game.Type, task, answer, deadline.Sub(now),
+				GetPlayerStreak(game, player, ""))
+			InsertScores(&Score{Game: game, Task: task, Player: player, Question: task.Question, Answer: answer,
+				Score: score, CreatedAt: now})
+		}
+	}
+
+	r, err := RescoreGame(game)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range r.Changes {
+		t.Errorf("%s: task %s rescored from %v to %v", change.Score.Player, change.Score.Question, change.Old,
+			change.Score.Score)
+	}
+}
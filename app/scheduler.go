@@ -0,0 +1,77 @@
+package app
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	schedulerPollPeriod = 10 * time.Second
+	schedulerLobbyLead  = 5 * time.Minute
+)
+
+// Scheduler drives scheduled games without their authors: it opens the lobby shortly before the
+// scheduled time, starts the game, moves through the tasks and finishes it.
+type Scheduler struct {
+	pool    *Pool
+	running map[int]struct{}
+	mu      sync.Mutex
+}
+
+func (s *Scheduler) Run() {
+	ticker := s.pool.clock.NewTicker(schedulerPollPeriod)
+	defer ticker.Stop()
+
+	for {
+		s.poll(s.pool.clock.Now())
+		<-ticker.C()
+	}
+}
+
+func (s *Scheduler) poll(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, game := range GetScheduledGames(now.Add(-schedulerLobbyLead), now.Add(schedulerLobbyLead)) {
+		if _, ok := s.running[game.ID]; ok || !game.IsLive() {
+			continue
+		}
+		s.running[game.ID] = struct{}{}
+		go s.drive(game)
+	}
+}
+
+func (s *Scheduler) drive(game *Game) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("error: scheduled game %d: %v", game.ID, err)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.running, game.ID)
+	}()
+
+	gp := s.pool.Open(game)
+	s.pool.clock.Sleep(game.ScheduledAt.Sub(s.pool.clock.Now()))
+
+	s.pool.startGame(game, gp)
+	for {
+		finished := make(chan struct{})
+		if task := s.pool.nextTask(game, gp, func() { close(finished) }); task == nil {
+			break
+		}
+		<-finished
+		s.pool.clock.Sleep(game.taskGap())
+	}
+	s.pool.finishGame(game, gp)
+}
+
+func NewScheduler(pool *Pool) *Scheduler {
+	return &Scheduler{
+		pool:    pool,
+		running: make(map[int]struct{}),
+	}
+}
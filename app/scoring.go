@@ -0,0 +1,160 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	ScoringSpeed = "speed"
+	ScoringFlat  = "flat"
+)
+
+const PowerUpDoubleOrNothing = "double_or_nothing"
+
+const (
+	correctAnswerBaseScore = 15_000
+	correctAnswerFlatScore = 1_000
+)
+
+// ScoringOptions let the author tune how quiz and find_cat answers are scored. The speed strategy
+// gives Points divided by the time to answer plus a millisecond bonus for every millisecond left,
+// the flat one gives Points for every correct answer. On top of that the score grows by StreakBonus
+// times itself for every preceding correct answer in a row (up to StreakLimit of them) and a wrong
+// answer costs Penalty.
+type ScoringOptions struct {
+	Strategy    string  `json:"strategy,omitempty"`
+	Points      float64 `json:"points,omitempty"`
+	Penalty     float64 `json:"penalty,omitempty"`
+	StreakBonus float64 `json:"streak_bonus,omitempty"`
+	StreakLimit int     `json:"streak_limit,omitempty"`
+}
+
+func (o *ScoringOptions) Validate() error {
+	switch o.Strategy {
+	case "", ScoringSpeed, ScoringFlat:
+	default:
+		return fmt.Errorf("unknown scoring strategy %q", o.Strategy)
+	}
+	if o.Points < 0 || o.Penalty < 0 || o.StreakBonus < 0 || o.StreakLimit < 0 {
+		return errors.New("scoring values must not be negative")
+	}
+	return nil
+}
+
+// scoredAnswer is an answer to a task as seen by a scoring strategy.
+type scoredAnswer struct {
+	hit       float64       // how correct the answer is, from 0 to 1
+	given     bool          // false if the player did not answer
+	remaining time.Duration // time left until the deadline when answered
+	streak    int           // correct answers in a row before this one
+}
+
+type scoringStrategy interface {
+	score(task *Task, answer scoredAnswer) float64
+}
+
+type speedScoring struct {
+	points float64
+}
+
+func (s speedScoring) score(task *Task, answer scoredAnswer) float64 {
+	if answer.hit == 0 {
+		return 0
+	}
+	baseScore := s.points / math.Max(float64(task.TimeToAnswer), 1)
+	return answer.hit * (baseScore + float64(answer.remaining.Milliseconds()))
+}
+
+type flatScoring struct {
+	points float64
+}
+
+func (s flatScoring) score(_ *Task, answer scoredAnswer) float64 {
+	return answer.hit * s.points
+}
+
+type streakScoring struct {
+	scoringStrategy
+	bonus float64
+	limit int
+}
+
+func (s streakScoring) score(task *Task, answer scoredAnswer) float64 {
+	score := s.scoringStrategy.score(task, answer)
+	streak := answer.streak
+	if s.limit > 0 && streak > s.limit {
+		streak = s.limit
+	}
+	return score * (1 + s.bonus*float64(streak))
+}
+
+type negativeScoring struct {
+	scoringStrategy
+	penalty float64
+}
+
+func (s negativeScoring) score(task *Task, answer scoredAnswer) float64 {
+	if answer.given && answer.hit == 0 {
+		return -s.penalty
+	}
+	return s.scoringStrategy.score(task, answer)
+}
+
+func newScoringStrategy(options ScoringOptions) scoringStrategy {
+	var strategy scoringStrategy
+	switch options.Strategy {
+	case ScoringFlat:
+		points := options.Points
+		if points == 0 {
+			points = correctAnswerFlatScore
+		}
+		strategy = flatScoring{points: points}
+	default:
+		points := options.Points
+		if points == 0 {
+			points = correctAnswerBaseScore
+		}
+		strategy = speedScoring{points: points}
+	}
+	if options.StreakBonus > 0 {
+		strategy = streakScoring{strategy, options.StreakBonus, options.StreakLimit}
+	}
+	if options.Penalty > 0 {
+		strategy = negativeScoring{strategy, options.Penalty}
+	}
+	return strategy
+}
+
+// scoreAnswer scores the answer to a quiz or find_cat task, multiplied by the task multiplier.
+func scoreAnswer(strategy scoringStrategy, gameType string, task *Task, answer string, remaining time.Duration, streak int) float64 {
+	hit := 0.0
+	if task.isImage(gameType) {
+		regions, err := ParseRegions(task.CorrectAnswer)
+		if err != nil {
+			log.Printf("error: task %d: %v", task.ID, err)
+			return 0
+		}
+		hit = regions.ScoreAnswer(answer)
+	} else if answer == task.CorrectAnswer {
+		hit = 1
+	}
+	return task.multiplier() * strategy.score(task, scoredAnswer{
+		hit:       hit,
+		given:     answer != "",
+		remaining: remaining,
+		streak:    streak,
+	})
+}
+
+// doubleOrNothing doubles the score of a correct answer, while a wrong or a missing one costs as
+// much as a correct answer given at the last moment.
+func doubleOrNothing(strategy scoringStrategy, task *Task, score float64) float64 {
+	if score > 0 {
+		return 2 * score
+	}
+	return score - task.multiplier()*strategy.score(task, scoredAnswer{hit: 1})
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScoreAnswer(t *testing.T) {
+	task := &Task{Answers: []string{"3", "4"}, CorrectAnswer: "4", TimeToAnswer: 10}
+	doubled := &Task{Answers: []string{"3", "4"}, CorrectAnswer: "4", TimeToAnswer: 10, Multiplier: 2}
+	full := task.timeToAnswer()
+
+	tests := []struct {
+		name      string
+		options   ScoringOptions
+		task      *Task
+		answer    string
+		remaining time.Duration
+		streak    int
+		want      float64
+	}{
+		{"speed at the deadline", ScoringOptions{}, task, "4", 0, 0, 1500},
+		{"speed at once", ScoringOptions{}, task, "4", full, 0, 11500},
+		{"speed wrong at once", ScoringOptions{}, task, "3", full, 0, 0},
+		{"speed with points", ScoringOptions{Points: 30000}, task, "4", 0, 0, 3000},
+		{"speed doubled", ScoringOptions{}, doubled, "4", full, 0, 23000},
+		{"flat at the deadline", ScoringOptions{Strategy: ScoringFlat}, task, "4", 0, 0, 1000},
+		{"flat at once", ScoringOptions{Strategy: ScoringFlat}, task, "4", full, 0, 1000},
+		{"flat wrong", ScoringOptions{Strategy: ScoringFlat}, task, "3", full, 0, 0},
+		{"flat doubled", ScoringOptions{Strategy: ScoringFlat, Points: 10}, doubled, "4", 0, 0, 20},
+		{"negative right", ScoringOptions{Strategy: ScoringFlat, Penalty: 250}, task, "4", 0, 0, 1000},
+		{"negative wrong", ScoringOptions{Strategy: ScoringFlat, Penalty: 250}, task, "3", full, 0, -250},
+		{"negative wrong doubled", ScoringOptions{Strategy: ScoringFlat, Penalty: 250}, doubled, "3", 0, 0, -500},
+		{"negative speed wrong", ScoringOptions{Penalty: 250}, task, "3", full, 0, -250},
+		{"negative no answer", ScoringOptions{Strategy: ScoringFlat, Penalty: 250}, task, "", 0, 0, 0},
+		{"streak", ScoringOptions{Strategy: ScoringFlat, StreakBonus: 0.5}, task, "4", 0, 2, 2000},
+		{"streak over the limit", ScoringOptions{Strategy: ScoringFlat, StreakBonus: 0.5, StreakLimit: 1}, task, "4",
+			0, 2, 1500},
+		{"streak wrong", ScoringOptions{Strategy: ScoringFlat, StreakBonus: 0.5}, task, "3", 0, 2, 0},
+		{"streak negative wrong", ScoringOptions{Strategy: ScoringFlat, StreakBonus: 0.5, Penalty: 250}, task, "3",
+			0, 2, -250},
+	}
+	for _, tt := range tests {
+		got := scoreAnswer(newScoringStrategy(tt.options), GameTypeQuiz, tt.task, tt.answer, tt.remaining, tt.streak)
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDoubleOrNothing(t *testing.T) {
+	task := &Task{Answers: []string{"3", "4"}, CorrectAnswer: "4", TimeToAnswer: 10}
+	doubled := &Task{Answers: []string{"3", "4"}, CorrectAnswer: "4", TimeToAnswer: 10, Multiplier: 2}
+
+	tests := []struct {
+		name    string
+		options ScoringOptions
+		task    *Task
+		answer  string
+		want    float64
+	}{
+		{"flat right", ScoringOptions{Strategy: ScoringFlat}, task, "4", 2000},
+		{"flat wrong", ScoringOptions{Strategy: ScoringFlat}, task, "3", -1000},
+		{"flat no answer", ScoringOptions{Strategy: ScoringFlat}, task, "", -1000},
+		{"flat wrong doubled", ScoringOptions{Strategy: ScoringFlat}, doubled, "3", -2000},
+		{"speed wrong", ScoringOptions{}, task, "3", -1500},
+		{"speed no answer", ScoringOptions{}, task, "", -1500},
+		{"negative wrong", ScoringOptions{Strategy: ScoringFlat, Penalty: 250}, task, "3", -1250},
+		{"negative no answer", ScoringOptions{Strategy: ScoringFlat, Penalty: 250}, task, "", -1000},
+	}
+	for _, tt := range tests {
+		strategy := newScoringStrategy(tt.options)
+		score := scoreAnswer(strategy, GameTypeQuiz, tt.task, tt.answer, 0, 0)
+		if got := doubleOrNothing(strategy, tt.task, score); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
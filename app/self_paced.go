@@ -0,0 +1,167 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const selfPacedGracePeriod = 2 * time.Second
+
+// selfPacedForm is the query of the pages of self-paced games, find_cat ones included: the player,
+// the key telling players of the same name apart, the index of the task and the answer to it.
+type selfPacedForm struct {
+	Player      string `form:"p"`
+	Key         string `form:"k"`
+	Index       int    `form:"w"`
+	Answer      string `form:"v"`
+	ErrorPlayer bool   `form:"ep"`
+}
+
+func SelfPaced(c *gin.Context) {
+	game := c.MustGet("game").(*Game)
+	now := DefaultClock.Now()
+
+	if (game.OpensAt != nil && now.Before(*game.OpensAt)) || (game.ClosesAt != nil && now.After(*game.ClosesAt)) {
+		c.HTML(http.StatusOK, "self_paced", gin.H{"game": game, "closed": true})
+		return
+	}
+
+	tasks := SessionTasks(game)
+
+	var form selfPacedForm
+	_ = c.ShouldBind(&form)
+	if form.Player == "" {
+		c.HTML(http.StatusOK, "self_paced", gin.H{"game": game, "form": form})
+		return
+	}
+
+	player := StripHtmlTags(form.Player)
+	if form.Key == "" {
+		url := c.Request.URL
+		query := url.Query()
+		query.Add("k", RandString(4))
+		url.RawQuery = query.Encode()
+		c.Redirect(http.StatusTemporaryRedirect, url.String())
+		c.Abort()
+		return
+	}
+
+	if HasPlayerInScores(game, player, form.Key) {
+		c.Redirect(http.StatusTemporaryRedirect, c.Request.URL.Path+"?ep=1")
+		c.Abort()
+		return
+	}
+	if game.LastStartedAt == nil || (game.OpensAt != nil && game.LastStartedAt.Before(*game.OpensAt)) {
+		startedAt := now
+		if game.OpensAt != nil {
+			startedAt = *game.OpensAt
+		}
+		UpdateGameStartedAt(game, startedAt)
+		// the order of the tasks follows the start as stored, which every later request reads
+		game = GetGame(game.ID)
+		tasks = SessionTasks(game)
+	}
+	tasks = playerOrder(game, tasks, player, form.Key)
+
+	index := form.Index
+	if index < 0 {
+		index = 0
+	} else if index >= len(tasks) {
+		c.HTML(http.StatusOK, "podium", gin.H{"scores": GetScores(game), "player": player})
+		return
+	}
+	task := tasks[index]
+	form.Index = index
+
+	deadline := DeliverTask(game, task, player, form.Key, now).Add(task.timeToAnswer())
+	expired := now.After(deadline.Add(selfPacedGracePeriod))
+
+	ctx := gin.H{
+		"game":    game,
+		"form":    form,
+		"task":    answerOrder(game, *game.LastStartedAt, task, player, form.Key),
+		"image":   task.isImage(game.Type),
+		"counter": fmt.Sprintf("%d / %d", index+1, len(tasks)),
+	}
+
+	if form.Answer == "" && now.Before(deadline) {
+		ctx["remaining"] = int(math.Ceil(deadline.Sub(now).Seconds()))
+		c.HTML(http.StatusOK, "self_paced", ctx)
+		return
+	}
+
+	answer := form.Answer
+	if expired {
+		answer = ""
+	}
+	score := 0.0
+	if game.Type != GameTypeWoC {
+		remaining := deadline.Sub(now)
+		if remaining < 0 {
+			remaining = 0
+		}
+		streak := GetPlayerStreak(game, player, form.Key)
+		score = scoreAnswer(newScoringStrategy(game.Options.Scoring), game.Type, task, answer, remaining, streak)
+	}
+	InsertScores(&Score{
+		Game:      game,
+		Task:      task,
+		Player:    player,
+		PlayerKey: form.Key,
+		Question:  task.Question,
+		Answer:    answer,
+		Score:     score,
+		CreatedAt: now,
+	})
+
+	var scores []*Score
+	if game.Type == GameTypeWoC {
+		scores = rescoreWoCTask(game, task)
+	} else {
+		scores = GetTaskScores(game, task)
+	}
+	for _, sc := range scores {
+		if sc.Player == player && sc.PlayerKey == form.Key {
+			ctx["result"] = sc
+			break
+		}
+	}
+	ctx["expired"] = answer == ""
+	c.HTML(http.StatusOK, "self_paced", ctx)
+}
+
+// rescoreWoCTask ranks all estimates given for the task in the current session again, as every new
+// estimate may shift the ranks of the others.
+func rescoreWoCTask(game *Game, task *Task) []*Score {
+	scores := GetTaskScores(game, task)
+	numeric := make([]*Score, 0, len(scores))
+	estimates := make([]float64, 0, len(scores))
+	for _, score := range scores {
+		if estimate, err := parseEstimate(score.Answer); err == nil {
+			numeric = append(numeric, score)
+			estimates = append(estimates, estimate)
+		}
+	}
+
+	correctAnswer, err := parseEstimate(task.CorrectAnswer)
+	values := []float64(nil)
+	if err == nil {
+		values, _, err = scoreWoC(game.Options.WoC, correctAnswer, estimates)
+	}
+	if err != nil {
+		log.Printf("error: task %d: %v", task.ID, err)
+		return scores
+	}
+	for i, score := range numeric {
+		if value := task.multiplier() * values[i]; score.Score != value {
+			score.Score = value
+			UpdateScore(score)
+		}
+	}
+	return scores
+}
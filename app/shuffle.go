@@ -0,0 +1,94 @@
+package app
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+const (
+	ShuffleSession = "session"
+	ShufflePlayer  = "player"
+)
+
+// ShuffleOptions put the tasks and the answers to choose from in a random order, the same for the
+// whole session or different for every player. Live games ask every player the same task at once,
+// so their tasks are shuffled per session either way. Answers are stored and scored by their text,
+// whatever order they were shown in.
+type ShuffleOptions struct {
+	Tasks   string `json:"tasks,omitempty"`
+	Answers string `json:"answers,omitempty"`
+}
+
+func (o *ShuffleOptions) Validate() error {
+	for _, shuffle := range []string{o.Tasks, o.Answers} {
+		switch shuffle {
+		case "", ShuffleSession, ShufflePlayer:
+		default:
+			return fmt.Errorf("unknown shuffle %q", shuffle)
+		}
+	}
+	return nil
+}
+
+// shuffleSeed derives the seed of a shuffle from its parts, so that the order comes out the same
+// every time it is asked for, e.g. when a self-paced page is reloaded or a session is rescored.
+func shuffleSeed(parts ...interface{}) int64 {
+	h := fnv.New64a()
+	for _, part := range parts {
+		_, _ = fmt.Fprintf(h, "%v\x00", part)
+	}
+	return int64(h.Sum64())
+}
+
+// shuffleSession identifies the session started at the time in seeds. Only whole seconds are taken,
+// as the start is kept in memory to the nanosecond but stored to the microsecond, so that the order
+// comes out the same from the gameplay, the store and the event log.
+func shuffleSession(startedAt time.Time) int64 {
+	return startedAt.UTC().Truncate(time.Second).Unix()
+}
+
+func shuffleTasks(tasks []*Task, seed int64) []*Task {
+	shuffled := make([]*Task, len(tasks))
+	for i, j := range rand.New(rand.NewSource(seed)).Perm(len(tasks)) {
+		shuffled[i] = tasks[j]
+	}
+	return shuffled
+}
+
+// sessionOrder returns the tasks in the order of the session of the game started at the time.
+func sessionOrder(game *Game, startedAt time.Time, tasks []*Task) []*Task {
+	if game.Options.Shuffle.Tasks == "" {
+		return tasks
+	}
+	return shuffleTasks(tasks, shuffleSeed(game.ID, shuffleSession(startedAt)))
+}
+
+// playerOrder returns the tasks of the current session of a self-paced game in the order of the
+// player.
+func playerOrder(game *Game, tasks []*Task, player string, playerKey string) []*Task {
+	if game.Options.Shuffle.Tasks != ShufflePlayer || game.LastStartedAt == nil {
+		return tasks
+	}
+	return shuffleTasks(tasks, shuffleSeed(game.ID, shuffleSession(*game.LastStartedAt), player, playerKey))
+}
+
+// answerOrder returns a copy of the task with the answers in the order of the player, or of the
+// session if the answers are not shuffled per player or the player is empty, e.g. for spectators.
+func answerOrder(game *Game, startedAt time.Time, task *Task, player string, playerKey string) *Task {
+	switch game.Options.Shuffle.Answers {
+	case ShuffleSession:
+		player, playerKey = "", ""
+	case ShufflePlayer:
+	default:
+		return task
+	}
+	seed := shuffleSeed(game.ID, shuffleSession(startedAt), task.ID, player, playerKey)
+	shuffled := *task
+	shuffled.Answers = make([]string, len(task.Answers))
+	for i, j := range rand.New(rand.NewSource(seed)).Perm(len(task.Answers)) {
+		shuffled.Answers[i] = task.Answers[j]
+	}
+	return &shuffled
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestShuffleSurvivesStorage(t *testing.T) {
+	tasks := make([]*Task, 20)
+	for i := range tasks {
+		tasks[i] = &Task{ID: i + 1, Question: fmt.Sprint(i + 1), Answers: []string{"a", "b", "c", "d", "e", "f"}}
+	}
+	game := &Game{ID: 1, Options: GameOptions{Shuffle: ShuffleOptions{Tasks: ShufflePlayer, Answers: ShufflePlayer}}}
+
+	// a server away from UTC, whose wall clock a timestamp column would keep without the zone
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+
+	// the start as the gameplay keeps it and as PostgreSQL gives it back
+	startedAt := time.Date(2021, 6, 1, 12, 0, 0, 123456789, time.Local)
+	stored := timestampColumn(utc(startedAt))
+
+	order := func(startedAt time.Time) string {
+		g := *game
+		g.LastStartedAt = &startedAt
+		return fmt.Sprint(
+			taskIDs(sessionOrder(&g, startedAt, tasks)),
+			taskIDs(playerOrder(&g, tasks, "alice", "k")),
+			answerOrder(&g, startedAt, tasks[0], "alice", "k").Answers,
+		)
+	}
+	if got, want := order(stored), order(startedAt); got != want {
+		t.Errorf("got order %s from the store, want %s", got, want)
+	}
+	if order(startedAt) == order(startedAt.Add(time.Second)) {
+		t.Error("sessions a second apart are shuffled the same")
+	}
+}
+
+// timestampColumn returns the time as read back from a timestamp column: to the microsecond, with the
+// wall clock it was written with, in UTC.
+func timestampColumn(t time.Time) time.Time {
+	t = t.Round(time.Microsecond)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+func taskIDs(tasks []*Task) []int {
+	ids := make([]int, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+	return ids
+}
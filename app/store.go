@@ -0,0 +1,159 @@
+package app
+
+import (
+	"time"
+)
+
+// Store keeps the games, their tasks, the question bank and the scores of the players. Scores and deliveries belong to
+// the current session of a game, which begins when the game was last started.
+type Store interface {
+	GetGames() []*Game
+	// GetScheduledGames returns the games scheduled between from and to that have not been started
+	// since.
+	GetScheduledGames(from, to time.Time) []*Game
+	// GetGame returns nil if there is no game with the ID.
+	GetGame(id int) *Game
+	GetTasks(game *Game) []*Task
+	// InsertGame stores a new game with its tasks, all or nothing, and gives them their IDs.
+	InsertGame(game *Game, tasks []*Task)
+	UpdateGameStartedAt(game *Game, startedAt time.Time)
+
+	// InsertScores skips the scores of players who have already answered the task in the session.
+	InsertScores(scores ...*Score)
+	GetTaskScores(game *Game, task *Task) []*Score
+	UpdateScore(score *Score)
+	// DeliverTask records the moment the task was first shown to the player in the session and
+	// returns it, so that reloading the page does not give the player extra time to answer.
+	DeliverTask(game *Game, task *Task, player string, playerKey string, now time.Time) time.Time
+	GetDeliveries(game *Game, task *Task) []Delivery
+	GetScores(game *Game) []TotalScore
+	// GetPlayerStreak counts the latest scores of the player in the session that are positive.
+	GetPlayerStreak(game *Game, player string, playerKey string) int
+	// HasPlayerInScores reports whether another player with the name, but a different key, has
+	// played in the session.
+	HasPlayerInScores(game *Game, player string, playerKey string) bool
+
+	AppendEvents(events ...*Event)
+	// GetSessions returns the sessions of the game with logged events, latest first.
+	GetSessions(game *Game) []time.Time
+	GetEvents(game *Game, session time.Time) []*Event
+
+	// InsertQuestions adds the questions to the bank and gives them their IDs.
+	InsertQuestions(questions ...*Question)
+	// GetQuestion returns nil if there is no question with the ID.
+	GetQuestion(id int) *Question
+	GetQuestions(filter QuestionFilter) []*Question
+	// SaveDraws records the questions drawn by the tasks for the session, which GetTasks returns from
+	// then on. Draws already recorded for the session stay.
+	SaveDraws(game *Game, session time.Time, tasks []*Task)
+}
+
+// TotalScore is the score of a player for the session, with the percentage of tasks completed.
+type TotalScore struct {
+	Player    string  `json:"player"`
+	Score     float64 `json:"score"`
+	Completed int     `json:"completed"`
+}
+
+// Delivery is the moment a task of a self-paced game was first shown to a player in the session.
+type Delivery struct {
+	Player      string
+	PlayerKey   string
+	DeliveredAt time.Time
+}
+
+// DefaultStore is the store of the application, PostgreSQL unless replaced, e.g. by a MemoryStore in
+// tests.
+var DefaultStore Store = &sqlStore{}
+
+func GetGames() []*Game {
+	return DefaultStore.GetGames()
+}
+
+func GetScheduledGames(from, to time.Time) []*Game {
+	return DefaultStore.GetScheduledGames(from, to)
+}
+
+func GetGameByHash(hash string) *Game {
+	id := GameHashID.Decode(hash)
+	if id == -1 {
+		return nil
+	}
+	return DefaultStore.GetGame(id)
+}
+
+func GetGame(id int) *Game {
+	return DefaultStore.GetGame(id)
+}
+
+func (g *Game) GetTasks() []*Task {
+	return DefaultStore.GetTasks(g)
+}
+
+func InsertGame(game *Game, tasks []*Task) {
+	DefaultStore.InsertGame(game, tasks)
+}
+
+func UpdateGameStartedAt(game *Game, startedAt time.Time) {
+	DefaultStore.UpdateGameStartedAt(game, startedAt)
+}
+
+func InsertScores(scores ...*Score) {
+	DefaultStore.InsertScores(scores...)
+}
+
+func GetTaskScores(game *Game, task *Task) []*Score {
+	return DefaultStore.GetTaskScores(game, task)
+}
+
+func UpdateScore(score *Score) {
+	DefaultStore.UpdateScore(score)
+}
+
+func DeliverTask(game *Game, task *Task, player string, playerKey string, now time.Time) time.Time {
+	return DefaultStore.DeliverTask(game, task, player, playerKey, now)
+}
+
+func GetDeliveries(game *Game, task *Task) []Delivery {
+	return DefaultStore.GetDeliveries(game, task)
+}
+
+func GetScores(game *Game) []TotalScore {
+	return DefaultStore.GetScores(game)
+}
+
+func GetPlayerStreak(game *Game, player string, playerKey string) int {
+	return DefaultStore.GetPlayerStreak(game, player, playerKey)
+}
+
+func HasPlayerInScores(game *Game, player string, playerKey string) bool {
+	return DefaultStore.HasPlayerInScores(game, player, playerKey)
+}
+
+func AppendEvents(events ...*Event) {
+	DefaultStore.AppendEvents(events...)
+}
+
+func GetSessions(game *Game) []time.Time {
+	return DefaultStore.GetSessions(game)
+}
+
+func GetEvents(game *Game, session time.Time) []*Event {
+	return DefaultStore.GetEvents(game, session)
+}
+
+func InsertQuestions(questions ...*Question) {
+	DefaultStore.InsertQuestions(questions...)
+}
+
+func GetQuestion(id int) *Question {
+	return DefaultStore.GetQuestion(id)
+}
+
+func GetQuestions(filter QuestionFilter) []*Question {
+	return DefaultStore.GetQuestions(filter)
+}
+
+func SaveDraws(game *Game, session time.Time, tasks []*Task) {
+	DefaultStore.SaveDraws(game, session, tasks)
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/lokhman/kakadoo/protocol"
 )
 
 const (
@@ -13,38 +15,20 @@ const (
 	wirePongTimeout    = 60 * time.Second
 	wirePingPeriod     = (wirePongTimeout * 9) / 10
 	wireMaxMessageSize = 512
-)
 
-type wireMessageType int
-
-const (
-	wmtReady wireMessageType = iota
-	wmtPlayerRegistered
-	wmtPlayerUnregistered
-	wmtGameStarted
-	wmtNextQuestion
-	wmtTask
-	wmtTimer
-	wmtAnswer
-	wmtTaskFinished
-	wmtGameFinished
-
-	wmtNotReady     = -1
-	wmtPlayerExists = -2
+	answerProgressPeriod = 500 * time.Millisecond
 )
 
-type wireMessage struct {
-	Type wireMessageType `json:"type"`
-	Data interface{}     `json:"data,omitempty"`
-}
-
 var wireUpgrader = websocket.Upgrader{
 	ReadBufferSize:    1024,
 	WriteBufferSize:   1024,
 	EnableCompression: true,
+	Subprotocols:      protocol.Subprotocols(),
 }
 
 func wireReader(pool *Pool, player *Player) {
+	// the gameplay comes from Run once the player has joined it
+	var gp *gameplay
 	defer func() {
 		pool.unregister <- player
 		_ = player.ws.Close()
@@ -58,96 +42,251 @@ func wireReader(pool *Pool, player *Player) {
 	})
 
 	for {
-		var wm *wireMessage
+		var wm protocol.RawMessage
 		if err := player.ws.ReadJSON(&wm); err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
 				log.Printf("error: %v", err)
 			}
 			break
 		}
+		if gp == nil {
+			select {
+			case gp = <-player.joined:
+			default:
+				// still waiting for the game to open
+				continue
+			}
+		}
 
-		if player.IsAuthor {
+		if player.IsAuthor && !gp.IsScheduled() {
 			switch wm.Type {
-			case wmtGameStarted:
-				numTasks := player.gameplay.Start()
-				UpdateGameStartedAt(player.Game, time.Now())
+			case protocol.GameStarted:
+				pool.startGame(player.Game, gp)
+			case protocol.NextQuestion:
+				pool.nextTask(player.Game, gp, nil)
+			case protocol.GameFinished:
+				pool.finishGame(player.Game, gp)
+			}
+		}
+
+		switch wm.Type {
+		case protocol.Answer:
+			// every attempt is logged with the time it came, to settle disputes about being too late
+			gp.events.record(protocol.Answer, player.Name, wm.Data, pool.clock.Now())
+
+			var answer string
+			if err := wm.Decode(&answer); err != nil {
+				pool.reply(gp, player, &protocol.Message{
+					Type: protocol.AnswerRejected,
+					Data: protocol.AnswerRejectedData{Reason: protocol.RejectInvalid},
+				}, pool.clock.Now())
+				break
+			}
+			index, recorded, err := gp.Answer(player, answer)
+			if err != nil {
+				pool.reply(gp, player, &protocol.Message{
+					Type: protocol.AnswerRejected,
+					Data: protocol.AnswerRejectedData{Index: index, Reason: err.Error()},
+				}, pool.clock.Now())
+				break
+			}
+			pool.answerProgress(player.Game, gp)
+			pool.reply(gp, player, &protocol.Message{
+				Type: protocol.AnswerAccepted,
+				Data: protocol.AnswerAcceptedData{
+					Index:  index,
+					Answer: recorded.answer,
+					Time:   recorded.time,
+				},
+			}, recorded.time)
+		case protocol.PlayerReady:
+			var ready bool
+			if err := wm.Decode(&ready); err == nil && gp.SetReady(player, ready) {
+				gp.events.record(protocol.PlayerReady, player.Name, ready, pool.clock.Now())
 				pool.broadcast <- &broadcastMessage{
 					Game: player.Game,
-					Message: &wireMessage{
-						Type: wmtGameStarted,
-						Data: map[string]interface{}{
-							"num_tasks": numTasks,
-						},
+					Message: &protocol.Message{
+						Type: protocol.PlayerReady,
+						Data: protocol.PlayerReadyData{Name: player.Name, Ready: ready},
 					},
 				}
-			case wmtNextQuestion:
-				var task *Task
-				task = player.gameplay.NextTask(func(timer int) {
-					pool.broadcast <- &broadcastMessage{
-						Game: player.Game,
-						Message: &wireMessage{
-							Type: wmtTimer,
-							Data: timer,
-						},
-					}
-				}, func(gp *gameplay, task *Task) {
-					stats := make(map[string]int)
-					for _, answer := range gp.answers[gp.currentTaskIndex] {
-						if _, ok := stats[answer.answer]; !ok {
-							stats[answer.answer] = 0
-						}
-						stats[answer.answer]++
-					}
-					pool.broadcast <- &broadcastMessage{
-						Game: player.Game,
-						Message: &wireMessage{
-							Type: wmtTaskFinished,
-							Data: map[string]interface{}{
-								"index":          gp.currentTaskIndex,
-								"correct_answer": task.CorrectAnswer,
-								"stats":          stats,
-								"scores":         gp.scores.Leaderboard(),
-							},
-						},
-					}
-				})
-				if task != nil {
-					pool.broadcast <- &broadcastMessage{
-						Game: player.Game,
-						Message: &wireMessage{
-							Type: wmtTask,
-							Data: map[string]interface{}{
-								"index":          player.gameplay.currentTaskIndex,
-								"question":       task.Question,
-								"answers":        task.Answers,
-								"time_to_answer": task.TimeToAnswer,
-							},
-						},
-					}
+			}
+		case protocol.PowerUp:
+			var powerUp string
+			if err := wm.Decode(&powerUp); err == nil && gp.ArmPowerUp(player, powerUp) {
+				pool.reply(gp, player, &protocol.Message{
+					Type: protocol.PowerUp,
+					Data: powerUp,
+				}, pool.clock.Now())
+			}
+		}
+	}
+}
+
+// reply sends the message to the player of the gameplay alone and logs it as of the given time. The message goes
+// through Run, which closes the channel of a player it drops; it is logged right away though, so
+// that the log keeps the order in which the gameplay saw the answers.
+func (p *Pool) reply(gp *gameplay, player *Player, message *protocol.Message, at time.Time) {
+	gp.events.record(message.Type, player.Name, message.Data, at)
+	p.broadcast <- &broadcastMessage{
+		Game:    player.Game,
+		Message: message,
+		To:      func(_player *Player) bool { return _player == player },
+	}
+}
+
+func (p *Pool) startGame(game *Game, gp *gameplay) {
+	now := p.clock.Now()
+	numTasks := gp.Start(game, now)
+	UpdateGameStartedAt(game, now)
+	SaveDraws(game, now, gp.tasks)
+	data := protocol.GameStartedData{NumTasks: numTasks}
+	gp.events.record(protocol.GameStarted, "", data, now)
+	p.broadcast <- &broadcastMessage{
+		Game: game,
+		Message: &protocol.Message{
+			Type: protocol.GameStarted,
+			Data: data,
+		},
+	}
+}
+
+// nextTask starts the next task of the gameplay and calls finished, if given, once the task is over.
+// It returns nil if there is no task to start.
+func (p *Pool) nextTask(game *Game, gp *gameplay, finished func()) *Task {
+	task := gp.NextTask(func(timer int) {
+		p.broadcast <- &broadcastMessage{
+			Game: game,
+			Message: &protocol.Message{
+				Type: protocol.Timer,
+				Data: timer,
+			},
+		}
+	}, func(gp *gameplay, task *Task) func() {
+		stats := make(map[string]int)
+		for _, answer := range gp.answers[gp.currentTaskIndex] {
+			if _, ok := stats[answer.answer]; !ok {
+				stats[answer.answer] = 0
+			}
+			stats[answer.answer]++
+		}
+		data := protocol.TaskFinishedData{
+			Index:         gp.currentTaskIndex,
+			CorrectAnswer: task.CorrectAnswer,
+			Stats:         stats,
+			Scores:        gp.scores.Leaderboard(),
+			Teams:         gp.TeamLeaderboard(),
+			Streaks:       gp.Streaks(),
+		}
+		if task.isImage(gp.gameType) {
+			if regions, err := ParseRegions(task.CorrectAnswer); err == nil {
+				data.Regions = make([]protocol.Region, len(regions.Regions))
+				for i, region := range regions.Regions {
+					data.Regions[i] = region.data()
+				}
+			}
+			data.Heatmap = make([]protocol.Point, 0, len(gp.answers[gp.currentTaskIndex]))
+			for _, answer := range gp.answers[gp.currentTaskIndex] {
+				if point, err := ParsePoint(answer.answer); err == nil {
+					data.Heatmap = append(data.Heatmap, point.data())
 				}
-			case wmtGameFinished:
-				scores := player.gameplay.Finish()
-				pool.broadcast <- &broadcastMessage{
-					Game: player.Game,
-					Message: &wireMessage{
-						Type: wmtGameFinished,
-						Data: scores.Leaderboard(),
-					},
+			}
+		} else if gp.gameType == GameTypeWoC {
+			data.Distribution = gp.WoCDistribution(task)
+		}
+		// logged while the gameplay is locked, so that it comes before the next task in the log
+		gp.events.record(protocol.TaskFinished, "", data, p.clock.Now())
+		return func() {
+			p.broadcast <- &broadcastMessage{
+				Game: game,
+				Message: &protocol.Message{
+					Type: protocol.TaskFinished,
+					Data: data,
+				},
+			}
+			if finished != nil {
+				finished()
+			}
+		}
+	})
+	if task != nil {
+		data := protocol.TaskData{
+			Index:        gp.currentTaskIndex,
+			Question:     task.Question,
+			Answers:      answerOrder(game, gp.startedAt, task, "", "").Answers,
+			Image:        task.isImage(gp.gameType),
+			TimeToAnswer: task.TimeToAnswer,
+			AllowChange:  task.AllowChange,
+		}
+		// logged as of the start of the task, which the deadline of the answers counts from
+		gp.events.record(protocol.Task, "", data, gp.Deadline().Add(-task.timeToAnswer()))
+		bm := &broadcastMessage{
+			Game: game,
+			Message: &protocol.Message{
+				Type: protocol.Task,
+				Data: data,
+			},
+		}
+		if game.Options.Shuffle.Answers == ShufflePlayer {
+			bm.Each = func(player *Player) *protocol.Message {
+				if player.IsAuthor || player.IsSpectator {
+					return bm.Message
 				}
+				data := data
+				data.Answers = answerOrder(game, gp.startedAt, task, player.Name, "").Answers
+				return &protocol.Message{Type: protocol.Task, Data: data}
 			}
 		}
+		p.broadcast <- bm
+	}
+	return task
+}
 
-		switch wm.Type {
-		case wmtAnswer:
-			if answer, ok := wm.Data.(string); ok {
-				player.gameplay.Answer(player, answer)
-			}
+// answerProgress lets the author and the spectators know how many players have answered, at most
+// once in answerProgressPeriod.
+func (p *Pool) answerProgress(game *Game, gp *gameplay) {
+	if !gp.ThrottleProgress() {
+		return
+	}
+	p.clock.AfterFunc(answerProgressPeriod, func() {
+		index, answered, total := gp.Progress()
+		p.broadcast <- &broadcastMessage{
+			Game: game,
+			Message: &protocol.Message{
+				Type: protocol.AnswerProgress,
+				Data: protocol.AnswerProgressData{
+					Index:    index,
+					Answered: answered,
+					Total:    total,
+				},
+			},
+			To: func(player *Player) bool {
+				return player.IsAuthor || player.IsSpectator
+			},
 		}
+	})
+}
+
+func (p *Pool) finishGame(game *Game, gp *gameplay) {
+	scores, teams := gp.Finish()
+	JoinPins.Release(gp.pin)
+	data := protocol.GameFinishedData{
+		Scores: scores,
+		Teams:  teams,
+	}
+	gp.events.record(protocol.GameFinished, "", data, p.clock.Now())
+	gp.events.close()
+	p.broadcast <- &broadcastMessage{
+		Game: game,
+		Message: &protocol.Message{
+			Type: protocol.GameFinished,
+			Data: data,
+		},
 	}
 }
 
-func wireWriter(_ *Pool, player *Player) {
-	ticker := time.NewTicker(wirePingPeriod)
+func wireWriter(pool *Pool, player *Player) {
+	ticker := pool.clock.NewTicker(wirePingPeriod)
 	defer func() {
 		ticker.Stop()
 		_ = player.ws.Close()
@@ -165,7 +304,7 @@ func wireWriter(_ *Pool, player *Player) {
 			if err := player.ws.WriteJSON(wm); err != nil {
 				return
 			}
-		case <-ticker.C:
+		case <-ticker.C():
 			_ = player.ws.SetWriteDeadline(time.Now().Add(wireWriteTimeout))
 			if err := player.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
@@ -180,8 +319,19 @@ func WireHandler(pool *Pool, player *Player, w http.ResponseWriter, r *http.Requ
 		panic(err)
 	}
 
+	version, err := protocol.ParseSubprotocol(ws.Subprotocol())
+	if err != nil {
+		log.Printf("error: %v", err)
+		message := websocket.FormatCloseMessage(websocket.CloseProtocolError, err.Error())
+		_ = ws.WriteControl(websocket.CloseMessage, message, time.Now().Add(wireWriteTimeout))
+		_ = ws.Close()
+		return
+	}
+
 	player.ws = ws
-	player.send = make(chan *wireMessage, 1)
+	player.version = version
+	player.send = make(chan *protocol.Message, 1)
+	player.joined = make(chan *gameplay, 1)
 	pool.register <- player
 
 	go wireReader(pool, player)
@@ -0,0 +1,311 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lokhman/kakadoo/protocol"
+)
+
+const (
+	WoCMetricAbsolute = "absolute"
+	WoCMetricRelative = "relative"
+	WoCMetricLog      = "log"
+)
+
+const (
+	WoCRankingRank       = "rank"
+	WoCRankingPercentile = "percentile"
+)
+
+const (
+	WoCBaselineMean          = "mean"
+	WoCBaselineMedian        = "median"
+	WoCBaselineTrimmedMean   = "trimmed_mean"
+	WoCBaselineGeometricMean = "geometric_mean"
+)
+
+const (
+	wocEpsilon     = 1e-9
+	wocDefaultTrim = 0.1
+)
+
+// wocBaselinePlayers are the names of the pseudo-players that represent the crowd baselines.
+var wocBaselinePlayers = map[string]string{
+	WoCBaselineMean:          "Mean",
+	WoCBaselineMedian:        "Median",
+	WoCBaselineTrimmedMean:   "Trimmed mean",
+	WoCBaselineGeometricMean: "Geometric mean",
+}
+
+// WoCOptions configure wisdom-of-crowds scoring. Metric defines the error of an estimate: the
+// absolute difference to the correct answer, the difference relative to the correct answer or the
+// difference of logarithms, which suits answers spanning orders of magnitude. Ranking defines the
+// score: the average rank by error (the worst estimate gets 0) or the same as a percentile. Trim is
+// the share of estimates dropped from each end for the trimmed mean.
+type WoCOptions struct {
+	Metric    string   `json:"metric,omitempty"`
+	Ranking   string   `json:"ranking,omitempty"`
+	Baselines []string `json:"baselines,omitempty"`
+	Trim      float64  `json:"trim,omitempty"`
+}
+
+func (o *WoCOptions) Validate() error {
+	switch o.Metric {
+	case "", WoCMetricAbsolute, WoCMetricRelative, WoCMetricLog:
+	default:
+		return fmt.Errorf("unknown woc metric %q", o.Metric)
+	}
+	switch o.Ranking {
+	case "", WoCRankingRank, WoCRankingPercentile:
+	default:
+		return fmt.Errorf("unknown woc ranking %q", o.Ranking)
+	}
+	for _, baseline := range o.Baselines {
+		if _, ok := wocBaselinePlayers[baseline]; !ok {
+			return fmt.Errorf("unknown woc baseline %q", baseline)
+		}
+	}
+	if o.Trim < 0 || o.Trim >= 0.5 {
+		return errors.New("woc trim must be between 0 and 0.5")
+	}
+	return nil
+}
+
+func (o *WoCOptions) baselines() []string {
+	if len(o.Baselines) == 0 {
+		return []string{WoCBaselineMean, WoCBaselineMedian}
+	}
+	return o.Baselines
+}
+
+func (o *WoCOptions) trim() float64 {
+	if o.Trim == 0 {
+		return wocDefaultTrim
+	}
+	return o.Trim
+}
+
+// parseEstimate parses a finite number, unlike strconv.ParseFloat which accepts "NaN" and "Inf".
+func parseEstimate(s string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return value, nil
+}
+
+// wocError returns the error of the estimate by the metric, or +Inf if the estimate cannot be
+// measured by it, such as a non-positive estimate with the log metric.
+func wocError(metric string, estimate float64, correctAnswer float64) float64 {
+	switch metric {
+	case WoCMetricRelative:
+		if correctAnswer != 0 {
+			return math.Abs((estimate - correctAnswer) / correctAnswer)
+		}
+	case WoCMetricLog:
+		if estimate <= 0 {
+			return math.Inf(1)
+		}
+		return math.Abs(math.Log(estimate) - math.Log(correctAnswer))
+	}
+	return math.Abs(estimate - correctAnswer)
+}
+
+// wocBaseline calculates the baseline of the sorted estimates. It reports false if the baseline is
+// not defined for them, such as the geometric mean of non-positive estimates.
+func wocBaseline(baseline string, sorted []float64, trim float64) (float64, bool) {
+	n := len(sorted)
+	switch baseline {
+	case WoCBaselineMean:
+		return mean(sorted), true
+	case WoCBaselineMedian:
+		if n%2 == 0 {
+			return sorted[n/2-1]/2 + sorted[n/2]/2, true
+		}
+		return sorted[n/2], true
+	case WoCBaselineTrimmedMean:
+		k := int(trim * float64(n))
+		if 2*k >= n {
+			k = (n - 1) / 2
+		}
+		return mean(sorted[k : n-k]), true
+	case WoCBaselineGeometricMean:
+		if sorted[0] <= 0 {
+			return 0, false
+		}
+		logs := make([]float64, n)
+		for i, value := range sorted {
+			logs[i] = math.Log(value)
+		}
+		return math.Exp(mean(logs)), true
+	}
+	return 0, false
+}
+
+// mean is calculated incrementally, so that it does not overflow on very large values.
+func mean(values []float64) float64 {
+	m := 0.0
+	for i, value := range values {
+		m += (value - m) / float64(i+1)
+	}
+	return m
+}
+
+func nearlyEqual(a, b float64) bool {
+	if a == b {
+		return true
+	}
+	if math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return false
+	}
+	return math.Abs(a-b) <= wocEpsilon*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// scoreWoC ranks the estimates together with the crowd baselines by their error: the larger the
+// error, the lower the score. Estimates with nearly equal errors share the average of their ranks.
+// Scores of the baselines are returned by the names of their players.
+func scoreWoC(options WoCOptions, correctAnswer float64, estimates []float64) ([]float64, map[string]float64, error) {
+	scores := make([]float64, len(estimates))
+	baselines := make(map[string]float64)
+	if options.Metric == WoCMetricLog && correctAnswer <= 0 {
+		return scores, baselines, fmt.Errorf("correct answer %v must be positive for the log metric", correctAnswer)
+	}
+	if len(estimates) == 0 {
+		return scores, baselines, nil
+	}
+
+	sorted := make([]float64, len(estimates))
+	copy(sorted, estimates)
+	sort.Float64s(sorted)
+
+	type wocScore struct {
+		index    int
+		baseline string
+		error    float64
+	}
+	ranking := make([]*wocScore, 0, len(estimates)+len(options.baselines()))
+	for i, estimate := range estimates {
+		ranking = append(ranking, &wocScore{index: i, error: wocError(options.Metric, estimate, correctAnswer)})
+	}
+	for _, baseline := range options.baselines() {
+		if value, ok := wocBaseline(baseline, sorted, options.trim()); ok {
+			ranking = append(ranking, &wocScore{
+				index:    -1,
+				baseline: wocBaselinePlayers[baseline],
+				error:    wocError(options.Metric, value, correctAnswer),
+			})
+		}
+	}
+
+	sort.SliceStable(ranking, func(i, j int) bool {
+		return ranking[i].error > ranking[j].error
+	})
+
+	for start := 0; start < len(ranking); {
+		end := start + 1
+		for end < len(ranking) && nearlyEqual(ranking[start].error, ranking[end].error) {
+			end++
+		}
+		value := float64(start+end-1) / 2
+		if options.Ranking == WoCRankingPercentile {
+			value = 100
+			if len(ranking) > 1 {
+				value = 100 * float64(start+end-1) / 2 / float64(len(ranking)-1)
+			}
+		}
+		for _, score := range ranking[start:end] {
+			if score.index == -1 {
+				baselines[score.baseline] = value
+			} else {
+				scores[score.index] = value
+			}
+		}
+		start = end
+	}
+	return scores, baselines, nil
+}
+
+const (
+	wocMinBins = 5
+	wocMaxBins = 20
+)
+
+type wocBin = protocol.Bin
+
+type wocEstimate = protocol.Estimate
+
+// wocDistribution summarises the estimates for a chart: a histogram over the range of the estimates
+// and the correct answer, the values of the baselines and the estimate of every player. Scale is
+// "log" if the bins are spread logarithmically, which is the case for the log metric.
+type wocDistribution = protocol.Distribution
+
+func newWoCDistribution(options WoCOptions, correctAnswer float64, players []string, estimates []float64) *wocDistribution {
+	d := &wocDistribution{
+		Scale:         "linear",
+		CorrectAnswer: correctAnswer,
+		Bins:          make([]wocBin, 0),
+		Baselines:     make(map[string]float64),
+		Estimates:     make([]wocEstimate, len(estimates)),
+	}
+	for i, estimate := range estimates {
+		d.Estimates[i] = wocEstimate{Player: players[i], Estimate: estimate}
+	}
+	if len(estimates) == 0 {
+		return d
+	}
+
+	sorted := make([]float64, len(estimates))
+	copy(sorted, estimates)
+	sort.Float64s(sorted)
+	for _, baseline := range options.baselines() {
+		if value, ok := wocBaseline(baseline, sorted, options.trim()); ok {
+			d.Baselines[wocBaselinePlayers[baseline]] = value
+		}
+	}
+
+	from, to := math.Min(sorted[0], correctAnswer), math.Max(sorted[len(sorted)-1], correctAnswer)
+	scale, unscale := func(v float64) float64 { return v }, func(v float64) float64 { return v }
+	if options.Metric == WoCMetricLog && from > 0 {
+		d.Scale = "log"
+		scale, unscale = math.Log10, func(v float64) float64 { return math.Pow(10, v) }
+	}
+	lo, hi := scale(from), scale(to)
+	if hi-lo <= wocEpsilon*math.Max(math.Abs(lo), math.Abs(hi)) {
+		// the padding is relative, as adding 0.5 is lost on values beyond 2^53
+		pad := math.Max(0.5, wocEpsilon*math.Max(math.Abs(lo), math.Abs(hi)))
+		lo, hi = math.Max(lo-pad, -math.MaxFloat64), math.Min(hi+pad, math.MaxFloat64)
+	}
+
+	bins := int(math.Ceil(math.Sqrt(float64(len(estimates)))))
+	if bins < wocMinBins {
+		bins = wocMinBins
+	} else if bins > wocMaxBins {
+		bins = wocMaxBins
+	}
+	// hi-lo overflows for estimates of opposite signs near the limits of float64
+	width := hi/float64(bins) - lo/float64(bins)
+	if width <= 0 || math.IsInf(width, 0) || math.IsNaN(width) {
+		return d
+	}
+	d.Bins = make([]wocBin, bins)
+	for i := range d.Bins {
+		d.Bins[i].From = unscale(lo + float64(i)*width)
+		d.Bins[i].To = unscale(lo + float64(i+1)*width)
+	}
+	d.Bins[bins-1].To = unscale(hi)
+	for _, estimate := range estimates {
+		i, at := 0, (scale(estimate)-lo)/width
+		if at >= float64(bins) {
+			i = bins - 1
+		} else if at > 0 {
+			i = int(at)
+		}
+		d.Bins[i].Count++
+	}
+	return d
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScoreWoC(t *testing.T) {
+	tests := []struct {
+		name          string
+		options       WoCOptions
+		correctAnswer float64
+		estimates     []float64
+		scores        []float64
+		baselines     map[string]float64
+		err           bool
+	}{
+		{
+			name:          "rank",
+			correctAnswer: 20,
+			estimates:     []float64{10, 20, 40},
+			scores:        []float64{1, 3.5, 0},
+			baselines:     map[string]float64{"Mean": 2, "Median": 3.5},
+		},
+		{
+			name:          "percentile",
+			options:       WoCOptions{Ranking: WoCRankingPercentile},
+			correctAnswer: 20,
+			estimates:     []float64{10, 20, 40},
+			scores:        []float64{25, 87.5, 0},
+			baselines:     map[string]float64{"Mean": 50, "Median": 87.5},
+		},
+		{
+			name:          "trimmed mean",
+			options:       WoCOptions{Baselines: []string{WoCBaselineTrimmedMean}, Trim: 0.2},
+			correctAnswer: 3,
+			estimates:     []float64{1, 2, 3, 4, 100},
+			scores:        []float64{1, 2.5, 4.5, 2.5, 0},
+			baselines:     map[string]float64{"Trimmed mean": 4.5},
+		},
+		{
+			name:          "log",
+			options:       WoCOptions{Metric: WoCMetricLog, Baselines: []string{WoCBaselineMedian, WoCBaselineGeometricMean}},
+			correctAnswer: 10,
+			estimates:     []float64{-1, 10, 100},
+			scores:        []float64{0, 2.5, 1},
+			baselines:     map[string]float64{"Median": 2.5},
+		},
+		{
+			name:          "log of non-positive",
+			options:       WoCOptions{Metric: WoCMetricLog},
+			correctAnswer: 0,
+			estimates:     []float64{1},
+			err:           true,
+		},
+		{
+			name:          "no estimates",
+			correctAnswer: 1,
+			scores:        []float64{},
+			baselines:     map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		scores, baselines, err := scoreWoC(tt.options, tt.correctAnswer, tt.estimates)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: got no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(scores) != len(tt.scores) || len(baselines) != len(tt.baselines) {
+			t.Errorf("%s: got %v %v, want %v %v", tt.name, scores, baselines, tt.scores, tt.baselines)
+			continue
+		}
+		for i := range tt.scores {
+			if scores[i] != tt.scores[i] {
+				t.Errorf("%s: got scores %v, want %v", tt.name, scores, tt.scores)
+				break
+			}
+		}
+		for name, want := range tt.baselines {
+			if got, ok := baselines[name]; !ok || got != want {
+				t.Errorf("%s: got baselines %v, want %v", tt.name, baselines, tt.baselines)
+				break
+			}
+		}
+	}
+}
+
+func TestNewWoCDistribution(t *testing.T) {
+	tests := []struct {
+		name          string
+		options       WoCOptions
+		correctAnswer float64
+		estimates     []float64
+		scale         string
+		counts        []int
+	}{
+		{"linear", WoCOptions{}, 5, []float64{1, 2, 3, 4, 5, 6, 7, 8, 9}, "linear", []int{2, 2, 1, 2, 2}},
+		{"log", WoCOptions{Metric: WoCMetricLog}, 100, []float64{1, 10, 100, 1000, 10000}, "log", []int{1, 1, 1, 1, 1}},
+		{"log of non-positive", WoCOptions{Metric: WoCMetricLog}, 1, []float64{-1, 9}, "linear", []int{1, 0, 0, 0, 1}},
+		{"same", WoCOptions{}, 7, []float64{7, 7}, "linear", []int{0, 0, 2, 0, 0}},
+		{"same beyond 2^53", WoCOptions{}, 1e17, []float64{1e17, 1e17, 1e17}, "linear", []int{0, 0, 3, 0, 0}},
+		{"same at the limit", WoCOptions{}, math.MaxFloat64, []float64{math.MaxFloat64}, "linear", []int{0, 0, 0, 0, 1}},
+		{"overflowing range", WoCOptions{}, 0, []float64{-1e308, 1e308}, "linear", []int{1, 0, 0, 0, 1}},
+		{"no estimates", WoCOptions{}, 1, nil, "linear", []int{}},
+	}
+	for _, tt := range tests {
+		players := make([]string, len(tt.estimates))
+		for i := range players {
+			players[i] = string(rune('a' + i))
+		}
+		d := newWoCDistribution(tt.options, tt.correctAnswer, players, tt.estimates)
+		if d.Scale != tt.scale || len(d.Bins) != len(tt.counts) || len(d.Estimates) != len(tt.estimates) {
+			t.Errorf("%s: got %+v", tt.name, d)
+			continue
+		}
+		for i, bin := range d.Bins {
+			if bin.Count != tt.counts[i] {
+				t.Errorf("%s: got bins %+v, want counts %v", tt.name, d.Bins, tt.counts)
+				break
+			}
+			if math.IsInf(bin.From, 0) || math.IsInf(bin.To, 0) || !(bin.From < bin.To) {
+				t.Errorf("%s: got bin %+v", tt.name, bin)
+			}
+			if i > 0 && bin.From != d.Bins[i-1].To {
+				t.Errorf("%s: bin %+v does not follow %+v", tt.name, bin, d.Bins[i-1])
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`%s</Types>`
+	xlsxContentTypeSheet = `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`
+
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets>%s</sheets></workbook>`
+	xlsxWorkbookSheet = `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`
+
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`%s<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`
+	xlsxWorkbookRelsSheet = `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`
+
+	// the second cell format is bold, for the header
+	xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+		`</styleSheet>`
+
+	xlsxSheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetFooter = `</sheetData></worksheet>`
+)
+
+type xlsxFile struct {
+	name  string
+	write func(io.Writer) error
+}
+
+func xlsxEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// xlsxColumn returns the letters of the 0-based column, e.g. AA for 26.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxSheetName drops the characters Excel does not allow in sheet names and cuts it to 31.
+func xlsxSheetName(name string) string {
+	runes := make([]rune, 0, len(name))
+	for _, r := range name {
+		switch r {
+		case '[', ']', ':', '*', '?', '/', '\\':
+			continue
+		}
+		if len(runes) < 31 {
+			runes = append(runes, r)
+		}
+	}
+	return string(runes)
+}
+
+func writeXLSXSheet(w io.Writer, sheet *Sheet) error {
+	var b bytes.Buffer
+	b.WriteString(xlsxSheetHeader)
+	for i, row := range sheet.Rows {
+		fmt.Fprintf(&b, `<row r="%d">`, i+1)
+		style := ""
+		if i == 0 {
+			style = ` s="1"`
+		}
+		for j, cell := range row {
+			ref := xlsxColumn(j) + strconv.Itoa(i+1)
+			switch cell.(type) {
+			case nil:
+			case int, float64:
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%s</v></c>`, ref, style, formatCell(cell))
+			default:
+				fmt.Fprintf(&b, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`,
+					ref, style, xlsxEscape(formatCell(cell)))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(xlsxSheetFooter)
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// WriteXLSX writes the sheets as an Office Open XML workbook, the least of it that spreadsheets
+// open: inline strings, numbers and a bold header.
+func WriteXLSX(w io.Writer, sheets ...*Sheet) error {
+	var contentTypes, workbook, workbookRels bytes.Buffer
+	for i, sheet := range sheets {
+		fmt.Fprintf(&contentTypes, xlsxContentTypeSheet, i+1)
+		fmt.Fprintf(&workbook, xlsxWorkbookSheet, xlsxEscape(xlsxSheetName(sheet.Name)), i+1, i+1)
+		fmt.Fprintf(&workbookRels, xlsxWorkbookRelsSheet, i+1, i+1)
+	}
+
+	files := []xlsxFile{
+		{"[Content_Types].xml", xlsxString(fmt.Sprintf(xlsxContentTypes, contentTypes.String()))},
+		{"_rels/.rels", xlsxString(xlsxRels)},
+		{"xl/workbook.xml", xlsxString(fmt.Sprintf(xlsxWorkbook, workbook.String()))},
+		{"xl/_rels/workbook.xml.rels", xlsxString(fmt.Sprintf(xlsxWorkbookRels, workbookRels.String(), len(sheets)+1))},
+		{"xl/styles.xml", xlsxString(xlsxStyles)},
+	}
+	for i, sheet := range sheets {
+		sheet := sheet
+		files = append(files, xlsxFile{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), func(w io.Writer) error {
+			return writeXLSXSheet(w, sheet)
+		}})
+	}
+
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if err = file.write(fw); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func xlsxString(s string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+}
@@ -0,0 +1,288 @@
+// Package client connects to live games over the wire protocol, for bots, load generators and chat
+// integrations.
+//
+// A Client plays as one player, or as the host if the name is the author of the game. Messages of
+// the server are delivered as events with the payloads of the protocol package, for example:
+//
+//	c, err := client.Dial(ctx, client.Config{URL: "https://kakadoo.herokuapp.com", Game: "xYz", Player: "bot"})
+//	for event := range c.Events() {
+//		if task, ok := event.Data.(protocol.TaskData); ok {
+//			_ = c.Answer(task.Answers[0])
+//		}
+//	}
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/lokhman/kakadoo/protocol"
+)
+
+const (
+	writeTimeout = 10 * time.Second
+	// pingTimeout is how long the connection may be silent: the server pings every 54 seconds.
+	pingTimeout = 90 * time.Second
+	eventBuffer = 16
+)
+
+var ErrClosed = errors.New("client: connection closed")
+
+type Config struct {
+	// URL is the base URL of the server, such as "https://kakadoo.herokuapp.com".
+	URL string
+	// Game is the hash ID of the game, as in /play/:id.
+	Game   string
+	Player string
+	Team   string
+	// Presenter connects as a read-only spectator and ignores Player.
+	Presenter bool
+	// Dialer defaults to websocket.DefaultDialer.
+	Dialer *websocket.Dialer
+	Header http.Header
+}
+
+// WireURL returns the URL of the websocket of the game.
+func (c Config) WireURL() (string, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("client: unsupported scheme %q", u.Scheme)
+	}
+	// the game is escaped alone, so that a slash in it does not split the path
+	u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/play/" + url.PathEscape(c.Game) + "/wire"
+	if u.Path, err = url.PathUnescape(u.RawPath); err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	if c.Presenter {
+		query.Set("presenter", "1")
+	} else {
+		query.Set("player", c.Player)
+	}
+	if c.Team != "" {
+		query.Set("team", c.Team)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Event is a message from the server. Data holds the payload of the type, such as
+// protocol.TaskData for protocol.Task, an int for protocol.Timer or a string for protocol.PowerUp,
+// and is nil for messages without one.
+type Event struct {
+	Type     protocol.MessageType
+	Data     interface{}
+	Received time.Time
+}
+
+type Client struct {
+	conn    *websocket.Conn
+	version int
+	events  chan Event
+	writeMu sync.Mutex
+	err     error
+	done    chan struct{}
+	closing chan struct{}
+	once    sync.Once
+}
+
+// Dial connects to the game and negotiates the latest version of the protocol both sides speak.
+func Dial(ctx context.Context, config Config) (*Client, error) {
+	wireURL, err := config.WireURL()
+	if err != nil {
+		return nil, err
+	}
+	dialer := config.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	d := *dialer
+	d.Subprotocols = protocol.Subprotocols()
+
+	conn, resp, err := d.DialContext(ctx, wireURL, config.Header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("client: %v (%s)", err, resp.Status)
+		}
+		return nil, fmt.Errorf("client: %v", err)
+	}
+	version, err := protocol.ParseSubprotocol(conn.Subprotocol())
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("client: %v", err)
+	}
+
+	c := &Client{
+		conn:    conn,
+		version: version,
+		events:  make(chan Event, eventBuffer),
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+	go c.read()
+	return c, nil
+}
+
+// Version returns the negotiated version of the protocol.
+func (c *Client) Version() int {
+	return c.version
+}
+
+// Events returns the messages of the server in the order they came. The channel is closed when the
+// connection is, after which Err tells why. The server drops clients that fall behind, so events
+// should be consumed promptly.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Err returns the error that closed the connection, or nil if it was closed normally.
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+func (c *Client) read() {
+	defer func() {
+		close(c.events)
+		close(c.done)
+		_ = c.conn.Close()
+	}()
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(pingTimeout))
+	c.conn.SetPingHandler(func(data string) error {
+		_ = c.conn.SetReadDeadline(time.Now().Add(pingTimeout))
+		err := c.conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeTimeout))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+
+	for {
+		var raw protocol.RawMessage
+		if err := c.conn.ReadJSON(&raw); err != nil {
+			// the server closes without a status once it is done with the player
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway,
+				websocket.CloseNoStatusReceived) {
+				c.err = err
+			}
+			return
+		}
+		_ = c.conn.SetReadDeadline(time.Now().Add(pingTimeout))
+
+		event, err := decode(raw)
+		if err != nil {
+			c.err = err
+			return
+		}
+		event.Received = time.Now()
+		select {
+		case c.events <- event:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// decode decodes the payload of the message into the type of its spec.
+func decode(raw protocol.RawMessage) (Event, error) {
+	event := Event{Type: raw.Type}
+	spec := protocol.Lookup(raw.Type)
+	if spec == nil || spec.Data == nil || len(raw.Data) == 0 {
+		return event, nil
+	}
+	v := reflect.New(reflect.TypeOf(spec.Data))
+	if err := raw.Decode(v.Interface()); err != nil {
+		return event, fmt.Errorf("client: %v: %v", raw.Type, err)
+	}
+	event.Data = v.Elem().Interface()
+	return event, nil
+}
+
+func (c *Client) send(t protocol.MessageType, data interface{}) error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteJSON(&protocol.Message{Type: t, Data: data})
+}
+
+// Start starts the game. Only the host can start, move on and finish the game.
+func (c *Client) Start() error {
+	return c.send(protocol.GameStarted, nil)
+}
+
+// Next starts the next task.
+func (c *Client) Next() error {
+	return c.send(protocol.NextQuestion, nil)
+}
+
+func (c *Client) Finish() error {
+	return c.send(protocol.GameFinished, nil)
+}
+
+// Answer answers the current task. The server confirms with protocol.AnswerAccepted or
+// protocol.AnswerRejected.
+func (c *Client) Answer(answer string) error {
+	return c.send(protocol.Answer, answer)
+}
+
+// SetReady tells the others in the lobby whether the player is ready for the game to start.
+func (c *Client) SetReady(ready bool) error {
+	return c.send(protocol.PlayerReady, ready)
+}
+
+// ArmPowerUp arms the power-up for the next task. The server echoes it back if armed.
+func (c *Client) ArmPowerUp(powerUp string) error {
+	return c.send(protocol.PowerUp, powerUp)
+}
+
+// Close closes the connection, letting the server know the player has left.
+func (c *Client) Close() error {
+	c.once.Do(func() {
+		close(c.closing)
+	})
+
+	c.writeMu.Lock()
+	err := c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeTimeout))
+	c.writeMu.Unlock()
+
+	select {
+	case <-c.done:
+	case <-time.After(writeTimeout):
+		_ = c.conn.Close()
+	}
+	if err == websocket.ErrCloseSent {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/lokhman/kakadoo/protocol"
+)
+
+const testTimeout = 5 * time.Second
+
+// testServer serves the websocket of a game with the given function and returns the config to
+// dial it.
+func testServer(t *testing.T, serve func(conn *websocket.Conn)) Config {
+	upgrader := websocket.Upgrader{Subprotocols: protocol.Subprotocols()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		serve(conn)
+	}))
+	t.Cleanup(server.Close)
+	return Config{URL: server.URL, Game: "xYz", Player: "bot"}
+}
+
+func dial(t *testing.T, config Config) *Client {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	c, err := Dial(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// drain returns the types of the events until the channel is closed.
+func drain(t *testing.T, c *Client) []protocol.MessageType {
+	var types []protocol.MessageType
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case event, ok := <-c.Events():
+			if !ok {
+				return types
+			}
+			types = append(types, event.Type)
+		case <-timeout:
+			t.Fatalf("events are not closed after %v", types)
+		}
+	}
+}
+
+func TestWireURL(t *testing.T) {
+	tests := []struct {
+		config Config
+		want   string
+	}{
+		{Config{URL: "https://kakadoo.herokuapp.com", Game: "xYz", Player: "bot"},
+			"wss://kakadoo.herokuapp.com/play/xYz/wire?player=bot"},
+		{Config{URL: "http://localhost:8080/", Game: "xYz", Player: "a b", Team: "red"},
+			"ws://localhost:8080/play/xYz/wire?player=a+b&team=red"},
+		{Config{URL: "ws://localhost/kakadoo", Game: "x/y", Presenter: true},
+			"ws://localhost/kakadoo/play/x%2Fy/wire?presenter=1"},
+		{Config{URL: "http://localhost/a%20b/", Game: "x y", Player: "bot"},
+			"ws://localhost/a%20b/play/x%20y/wire?player=bot"},
+		{Config{URL: "ftp://localhost", Game: "xYz"}, ""},
+	}
+	for _, tt := range tests {
+		got, err := tt.config.WireURL()
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: got %q, want error", tt.config.URL, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.config.URL, got, err, tt.want)
+		}
+	}
+}
+
+func TestServerCloses(t *testing.T) {
+	tests := []struct {
+		name  string
+		close []byte
+	}{
+		{"normal closure", websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")},
+		{"no status", []byte{}},
+	}
+	for _, tt := range tests {
+		c := dial(t, testServer(t, func(conn *websocket.Conn) {
+			_ = conn.WriteJSON(&protocol.Message{Type: protocol.GameFull})
+			_ = conn.WriteMessage(websocket.CloseMessage, tt.close)
+			_, _, _ = conn.ReadMessage()
+		}))
+
+		if types := drain(t, c); len(types) != 1 || types[0] != protocol.GameFull {
+			t.Errorf("%s: got events %v", tt.name, types)
+		}
+		if err := c.Err(); err != nil {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+		if err := c.Answer("4"); err != ErrClosed {
+			t.Errorf("%s: got error %v answering after the close", tt.name, err)
+		}
+	}
+}
+
+func TestConnectionDropsMidGame(t *testing.T) {
+	c := dial(t, testServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteJSON(&protocol.Message{Type: protocol.Task, Data: protocol.TaskData{Question: "2 + 2"}})
+		_ = conn.WriteJSON(&protocol.Message{Type: protocol.Timer, Data: 9})
+		// gone without a close message, as when the network fails
+		_ = conn.UnderlyingConn().Close()
+	}))
+
+	types := drain(t, c)
+	if len(types) != 2 || types[0] != protocol.Task || types[1] != protocol.Timer {
+		t.Errorf("got events %v", types)
+	}
+	if c.Err() == nil {
+		t.Error("got no error after the connection dropped")
+	}
+	if err := c.Next(); err != ErrClosed {
+		t.Errorf("got error %v sending after the drop", err)
+	}
+}
+
+func TestMalformedPayload(t *testing.T) {
+	c := dial(t, testServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type": 6, "data": "nine"}`))
+		_, _, _ = conn.ReadMessage()
+	}))
+
+	if types := drain(t, c); len(types) != 0 {
+		t.Errorf("got events %v", types)
+	}
+	if c.Err() == nil {
+		t.Error("got no error for a malformed payload")
+	}
+}
+
+func TestClose(t *testing.T) {
+	closed := make(chan int, 1)
+	c := dial(t, testServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteJSON(&protocol.Message{Type: protocol.NotReady})
+		_, _, err := conn.ReadMessage()
+		if ce, ok := err.(*websocket.CloseError); ok {
+			closed <- ce.Code
+		}
+		close(closed)
+	}))
+	if c.Version() != protocol.Version {
+		t.Errorf("got version %d, want %d", c.Version(), protocol.Version)
+	}
+
+	// the client closes without reading its events
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-closed:
+		if code != websocket.CloseNormalClosure {
+			t.Errorf("got close code %d, want %d", code, websocket.CloseNormalClosure)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the server was not told the client left")
+	}
+	drain(t, c)
+	if err := c.Err(); err != nil {
+		t.Errorf("got error %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("closing again: %v", err)
+	}
+}
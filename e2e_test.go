@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lokhman/kakadoo/app"
+	"github.com/lokhman/kakadoo/client"
+	"github.com/lokhman/kakadoo/protocol"
+)
+
+const e2eTimeout = 10 * time.Second
+
+// e2eStore and e2eClock back every test, as the pool and the scheduler of a server outlive its test.
+var (
+	e2eStore = app.NewMemoryStore()
+	e2eClock = app.NewFakeClock(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	app.DefaultStore = e2eStore
+	app.DefaultClock = e2eClock
+	os.Exit(m.Run())
+}
+
+// e2eServer runs the whole application with the in-memory store behind a test server.
+type e2eServer struct {
+	t      *testing.T
+	server *httptest.Server
+}
+
+func newE2EServer(t *testing.T) *e2eServer {
+	server := httptest.NewServer(getRouter(getPool()))
+	t.Cleanup(server.Close)
+	return &e2eServer{t: t, server: server}
+}
+
+type e2eClient struct {
+	*client.Client
+	t    *testing.T
+	name string
+}
+
+// connect joins the game as the player and waits for the welcome message, so that players join in
+// the order they connect.
+func (s *e2eServer) connect(game *app.Game, name string) *e2eClient {
+	s.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), e2eTimeout)
+	defer cancel()
+	c, err := client.Dial(ctx, client.Config{URL: s.server.URL, Game: app.GameHashID.Encode(game.ID), Player: name})
+	if err != nil {
+		s.t.Fatalf("%s: %v", name, err)
+	}
+	s.t.Cleanup(func() {
+		_ = c.Close()
+	})
+	ec := &e2eClient{Client: c, t: s.t, name: name}
+	ec.expect(protocol.Ready)
+	return ec
+}
+
+// expect reads the next messages of the client and fails unless they are of the given types, in the
+// given order.
+func (c *e2eClient) expect(types ...protocol.MessageType) []client.Event {
+	c.t.Helper()
+
+	events := make([]client.Event, len(types))
+	for i, want := range types {
+		select {
+		case event, ok := <-c.Events():
+			if !ok {
+				c.t.Fatalf("%s: connection closed while waiting for %v: %v", c.name, want, c.Err())
+			}
+			if event.Type != want {
+				c.t.Fatalf("%s: got %v (%+v), want %v", c.name, event.Type, event.Data, want)
+			}
+			events[i] = event
+		case <-time.After(e2eTimeout):
+			c.t.Fatalf("%s: timed out waiting for %v", c.name, want)
+		}
+	}
+	return events
+}
+
+func (c *e2eClient) answer(answer string) {
+	c.t.Helper()
+
+	if err := c.Answer(answer); err != nil {
+		c.t.Fatalf("%s: %v", c.name, err)
+	}
+	accepted := c.expect(protocol.AnswerAccepted)[0].Data.(protocol.AnswerAcceptedData)
+	if accepted.Answer != answer {
+		c.t.Fatalf("%s: answer %q recorded as %q", c.name, answer, accepted.Answer)
+	}
+}
+
+// finishTask fast-forwards through a task of 2 seconds, once all players have answered.
+func finishTask(host *e2eClient, clients []*e2eClient, answered int) protocol.TaskFinishedData {
+	host.t.Helper()
+
+	e2eClock.Advance(500 * time.Millisecond)
+	progress := host.expect(protocol.AnswerProgress)[0].Data.(protocol.AnswerProgressData)
+	if progress.Answered != answered || progress.Total != answered {
+		host.t.Fatalf("got progress %+v, want %d answered", progress, answered)
+	}
+
+	e2eClock.Advance(500 * time.Millisecond)
+	for _, c := range clients {
+		if timer := c.expect(protocol.Timer)[0].Data.(int); timer != 1 {
+			c.t.Fatalf("%s: got timer %d, want 1", c.name, timer)
+		}
+	}
+
+	e2eClock.Advance(time.Second)
+	var finished protocol.TaskFinishedData
+	for _, c := range clients {
+		events := c.expect(protocol.Timer, protocol.TaskFinished)
+		if timer := events[0].Data.(int); timer != 0 {
+			c.t.Fatalf("%s: got timer %d, want 0", c.name, timer)
+		}
+		finished = events[1].Data.(protocol.TaskFinishedData)
+	}
+	return finished
+}
+
+func leaderboard(scores []protocol.Score) map[string]float64 {
+	board := make(map[string]float64, len(scores))
+	for _, score := range scores {
+		board[score.Player] = score.Score
+	}
+	return board
+}
+
+func persistedScores(game *app.Game) map[string]float64 {
+	scores := make(map[string]float64)
+	for _, score := range app.GetScores(game) {
+		scores[score.Player] = score.Score
+	}
+	return scores
+}
+
+// sessionEvents waits for the event log of the finished session to be written and returns it.
+func sessionEvents(t *testing.T, game *app.Game) []*app.Event {
+	t.Helper()
+
+	deadline := time.Now().Add(e2eTimeout)
+	for time.Now().Before(deadline) {
+		if sessions := app.GetSessions(game); len(sessions) > 0 {
+			events := app.GetEvents(game, sessions[0])
+			if n := len(events); n > 0 && events[n-1].Type == protocol.GameFinished {
+				return events
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for the event log")
+	return nil
+}
+
+func TestE2EQuiz(t *testing.T) {
+	game := e2eStore.AddGame(&app.Game{
+		Type:    app.GameTypeQuiz,
+		Mode:    app.GameModeLive,
+		Title:   "Quiz",
+		Author:  "host",
+		Options: app.GameOptions{Scoring: app.ScoringOptions{Strategy: app.ScoringFlat}},
+	}, &app.Task{
+		Question:      "2 + 2",
+		Answers:       []string{"3", "4"},
+		CorrectAnswer: "4",
+		TimeToAnswer:  2,
+	}, &app.Task{
+		Question:      "Capital of France",
+		Answers:       []string{"Paris", "Lyon"},
+		CorrectAnswer: "Paris",
+		TimeToAnswer:  2,
+		Multiplier:    2,
+	})
+	s := newE2EServer(t)
+
+	host := s.connect(game, "host")
+	alice := s.connect(game, "alice")
+	host.expect(protocol.PlayerRegistered)
+	bob := s.connect(game, "bob")
+	host.expect(protocol.PlayerRegistered)
+	alice.expect(protocol.PlayerRegistered)
+	players := []*e2eClient{alice, bob}
+
+	if err := host.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range append(players, host) {
+		if started := c.expect(protocol.GameStarted)[0].Data.(protocol.GameStartedData); started.NumTasks != 2 {
+			t.Fatalf("%s: got %d tasks, want 2", c.name, started.NumTasks)
+		}
+	}
+
+	answers := [][]string{{"4", "3"}, {"Lyon", "Paris"}}
+	for i, taskAnswers := range answers {
+		if err := host.Next(); err != nil {
+			t.Fatal(err)
+		}
+		for j, c := range players {
+			task := c.expect(protocol.Task)[0].Data.(protocol.TaskData)
+			if task.Index != i {
+				t.Fatalf("%s: got task %d, want %d", c.name, task.Index, i)
+			}
+			c.answer(taskAnswers[j])
+		}
+		host.expect(protocol.Task)
+		finished := finishTask(host, append(players, host), len(players))
+		if finished.Index != i || finished.Stats[taskAnswers[0]] != 1 || finished.Stats[taskAnswers[1]] != 1 {
+			t.Fatalf("got %+v", finished)
+		}
+	}
+
+	if err := host.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"host": 0, "alice": 1000, "bob": 2000}
+	for _, c := range append(players, host) {
+		finished := c.expect(protocol.GameFinished)[0].Data.(protocol.GameFinishedData)
+		if len(finished.Scores) != 3 || finished.Scores[0].Player != "bob" || finished.Scores[1].Player != "alice" {
+			t.Fatalf("%s: got leaderboard %+v", c.name, finished.Scores)
+		}
+		for name, score := range leaderboard(finished.Scores) {
+			if score != want[name] {
+				t.Fatalf("%s: got %v for %s, want %v", c.name, score, name, want[name])
+			}
+		}
+	}
+
+	persisted := persistedScores(game)
+	if len(persisted) != 2 || persisted["alice"] != 1000 || persisted["bob"] != 2000 {
+		t.Fatalf("got persisted scores %v", persisted)
+	}
+
+	recorded, recomputed, err := app.ReplayScores(game, sessionEvents(t, game))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, score := range leaderboard(recomputed) {
+		if score != want[name] || leaderboard(recorded)[name] != want[name] {
+			t.Fatalf("got replayed score %v for %s, want %v", score, name, want[name])
+		}
+	}
+
+	// with the tasks unchanged, rescoring the session changes nothing
+	rescore, err := app.RescoreGame(app.GetGameByHash(app.GameHashID.Encode(game.ID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rescore.Changes) != 0 {
+		t.Fatalf("got rescored changes %+v", rescore.Changes)
+	}
+
+	// changes are applied only by the admin and only if they are the ones reviewed
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	postRescore := func(s *e2eServer, password string, digest string) int {
+		req, err := http.NewRequest(http.MethodPost, s.server.URL+"/play/"+app.GameHashID.Encode(game.ID)+"/rescore",
+			strings.NewReader(url.Values{"digest": {digest}}.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if password != "" {
+			req.SetBasicAuth("admin", password)
+		}
+		resp, err := noRedirect.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := postRescore(s, "", rescore.Digest()); status != http.StatusForbidden {
+		t.Fatalf("rescore without an admin password set: got status %d", status)
+	}
+
+	defer func(password string) { app.AdminPassword = password }(app.AdminPassword)
+	app.AdminPassword = "secret"
+	admin := newE2EServer(t)
+	for _, tt := range []struct {
+		password string
+		digest   string
+		status   int
+	}{
+		{"", rescore.Digest(), http.StatusUnauthorized},
+		{"secret", "stale", http.StatusConflict},
+		{"secret", rescore.Digest(), http.StatusSeeOther},
+	} {
+		if status := postRescore(admin, tt.password, tt.digest); status != tt.status {
+			t.Fatalf("rescore with password %q and digest %q: got status %d, want %d", tt.password, tt.digest,
+				status, tt.status)
+		}
+	}
+}
+
+func TestE2EWoC(t *testing.T) {
+	game := e2eStore.AddGame(&app.Game{
+		Type:   app.GameTypeWoC,
+		Mode:   app.GameModeLive,
+		Title:  "Wisdom of the crowd",
+		Author: "host",
+	}, &app.Task{
+		Question:      "How many?",
+		CorrectAnswer: "100",
+		TimeToAnswer:  2,
+	})
+	s := newE2EServer(t)
+
+	host := s.connect(game, "host")
+	players := make([]*e2eClient, 0)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		c := s.connect(game, name)
+		host.expect(protocol.PlayerRegistered)
+		for _, p := range players {
+			p.expect(protocol.PlayerRegistered)
+		}
+		players = append(players, c)
+	}
+	alice, bob, carol := players[0], players[1], players[2]
+
+	if err := host.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range append(players, host) {
+		c.expect(protocol.GameStarted)
+	}
+	if err := host.Next(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range players {
+		c.expect(protocol.Task)
+	}
+	alice.answer("90")
+	bob.answer("100")
+	carol.answer("130")
+	host.expect(protocol.Task)
+
+	finished := finishTask(host, append(players, host), len(players))
+	if finished.Distribution == nil || len(finished.Distribution.Estimates) != 3 ||
+		finished.Distribution.CorrectAnswer != 100 {
+		t.Fatalf("got distribution %+v", finished.Distribution)
+	}
+
+	if err := host.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	var scores map[string]float64
+	for _, c := range append(players, host) {
+		scores = leaderboard(c.expect(protocol.GameFinished)[0].Data.(protocol.GameFinishedData).Scores)
+	}
+	if _, ok := scores["Median"]; !ok {
+		t.Fatalf("got no median baseline in %v", scores)
+	}
+	if !(scores["bob"] > scores["alice"] && scores["alice"] > scores["carol"]) {
+		t.Fatalf("got leaderboard %v, want bob ahead of alice ahead of carol", scores)
+	}
+
+	persisted := persistedScores(game)
+	if len(persisted) != 3 {
+		t.Fatalf("got persisted scores %v", persisted)
+	}
+	for name, score := range persisted {
+		if score != scores[name] {
+			t.Fatalf("got persisted score %v for %s, want %v", score, name, scores[name])
+		}
+	}
+}
+
+func TestE2EBankDraws(t *testing.T) {
+	// the bank outlives the test, so its tag is new
+	tag := fmt.Sprintf("math%d", len(app.GetQuestions(app.QuestionFilter{})))
+	bank := []*app.Question{
+		{Question: "2 + 2", Answers: []string{"3", "4"}, CorrectAnswer: "4", TimeToAnswer: 2, Multiplier: 1,
+			Tags: []string{tag}},
+		{Question: "3 * 3", Answers: []string{"9", "6"}, CorrectAnswer: "9", TimeToAnswer: 2, Multiplier: 1,
+			Tags: []string{tag}},
+		{Question: "Capital of France", Answers: []string{"Paris", "Lyon"}, CorrectAnswer: "Paris", TimeToAnswer: 2,
+			Multiplier: 1},
+	}
+	app.InsertQuestions(bank...)
+	correct := make(map[string]string)
+	for _, q := range bank {
+		correct[q.Question] = q.CorrectAnswer
+	}
+
+	draw := &app.QuestionFilter{Tags: []string{tag}}
+	game := e2eStore.AddGame(&app.Game{
+		Type:    app.GameTypeQuiz,
+		Mode:    app.GameModeLive,
+		Title:   "Bank",
+		Author:  "host",
+		Options: app.GameOptions{Scoring: app.ScoringOptions{Strategy: app.ScoringFlat}},
+	}, &app.Task{Draw: draw}, &app.Task{Draw: draw})
+	s := newE2EServer(t)
+
+	host := s.connect(game, "host")
+	alice := s.connect(game, "alice")
+	host.expect(protocol.PlayerRegistered)
+
+	if err := host.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*e2eClient{alice, host} {
+		if started := c.expect(protocol.GameStarted)[0].Data.(protocol.GameStartedData); started.NumTasks != 2 {
+			t.Fatalf("%s: got %d tasks, want 2", c.name, started.NumTasks)
+		}
+	}
+
+	asked := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		if err := host.Next(); err != nil {
+			t.Fatal(err)
+		}
+		task := alice.expect(protocol.Task)[0].Data.(protocol.TaskData)
+		if _, ok := correct[task.Question]; !ok || task.Question == "Capital of France" || asked[task.Question] {
+			t.Fatalf("got drawn question %q after %v", task.Question, asked)
+		}
+		asked[task.Question] = true
+		alice.answer(correct[task.Question])
+		host.expect(protocol.Task)
+		finishTask(host, []*e2eClient{alice, host}, 1)
+	}
+	if err := host.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	alice.expect(protocol.GameFinished)
+	host.expect(protocol.GameFinished)
+
+	// the draws of the session stay, so that its answers are scored against the questions asked
+	if persisted := persistedScores(game); persisted["alice"] != 2000 {
+		t.Fatalf("got persisted scores %v", persisted)
+	}
+	_, recomputed, err := app.ReplayScores(game, sessionEvents(t, game))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score := leaderboard(recomputed)["alice"]; score != 2000 {
+		t.Fatalf("got replayed score %v", score)
+	}
+	rescore, err := app.RescoreGame(app.GetGameByHash(app.GameHashID.Encode(game.ID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rescore.Changes) != 0 {
+		t.Fatalf("got rescored changes %+v", rescore.Changes)
+	}
+}
+
+func TestE2EShuffle(t *testing.T) {
+	tasks := []*app.Task{
+		{Question: "2 + 2", Answers: []string{"3", "4", "5"}, CorrectAnswer: "4", TimeToAnswer: 2},
+		{Question: "3 * 3", Answers: []string{"6", "9", "12"}, CorrectAnswer: "9", TimeToAnswer: 2},
+		{Question: "Capital of France", Answers: []string{"Paris", "Lyon", "Nice"}, CorrectAnswer: "Paris",
+			TimeToAnswer: 2},
+	}
+	correct := make(map[string]string)
+	for _, task := range tasks {
+		correct[task.Question] = task.CorrectAnswer
+	}
+	game := e2eStore.AddGame(&app.Game{
+		Type:   app.GameTypeQuiz,
+		Mode:   app.GameModeLive,
+		Title:  "Shuffle",
+		Author: "host",
+		Options: app.GameOptions{
+			Scoring: app.ScoringOptions{Strategy: app.ScoringFlat},
+			Shuffle: app.ShuffleOptions{Tasks: app.ShuffleSession, Answers: app.ShufflePlayer},
+		},
+	}, tasks...)
+	s := newE2EServer(t)
+
+	host := s.connect(game, "host")
+	alice := s.connect(game, "alice")
+	host.expect(protocol.PlayerRegistered)
+	bob := s.connect(game, "bob")
+	host.expect(protocol.PlayerRegistered)
+	alice.expect(protocol.PlayerRegistered)
+	players := []*e2eClient{alice, bob}
+
+	if err := host.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range append(players, host) {
+		c.expect(protocol.GameStarted)
+	}
+	asked := make([]string, 0, len(tasks))
+	for i := range tasks {
+		if err := host.Next(); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range players {
+			task := c.expect(protocol.Task)[0].Data.(protocol.TaskData)
+			if task.Index != i || len(task.Answers) != 3 {
+				t.Fatalf("%s: got task %+v", c.name, task)
+			}
+			if c == alice {
+				asked = append(asked, task.Question)
+			}
+			// the answers come in any order, but are answered and scored by their text
+			c.answer(correct[task.Question])
+		}
+		host.expect(protocol.Task)
+		finishTask(host, append(players, host), len(players))
+	}
+	if err := host.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range append(players, host) {
+		c.expect(protocol.GameFinished)
+	}
+
+	if persisted := persistedScores(game); persisted["alice"] != 3000 || persisted["bob"] != 3000 {
+		t.Fatalf("got persisted scores %v", persisted)
+	}
+	events := sessionEvents(t, game)
+	for i, task := range app.ReplayTasks(game, events) {
+		if task.Question != asked[i] {
+			t.Fatalf("got replayed task %d %q, want %q", i, task.Question, asked[i])
+		}
+	}
+	_, recomputed, err := app.ReplayScores(game, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if board := leaderboard(recomputed); board["alice"] != 3000 || board["bob"] != 3000 {
+		t.Fatalf("got replayed scores %v", board)
+	}
+	rescore, err := app.RescoreGame(app.GetGameByHash(app.GameHashID.Encode(game.ID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rescore.Changes) != 0 {
+		t.Fatalf("got rescored changes %+v", rescore.Changes)
+	}
+}
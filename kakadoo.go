@@ -2,19 +2,79 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/gin-contrib/multitemplate"
 	"github.com/gin-gonic/gin"
 	"github.com/lokhman/kakadoo/app"
+	"github.com/lokhman/kakadoo/protocol"
+	"github.com/skip2/go-qrcode"
 )
 
+const qrCodeSize = 320
+
+// adminOnly asks for the admin password, if one is set.
+func adminOnly() gin.HandlerFunc {
+	if app.AdminPassword == "" {
+		return func(c *gin.Context) {}
+	}
+	return gin.BasicAuth(gin.Accounts{"admin": app.AdminPassword})
+}
+
+// adminRequired asks for the admin password and, unlike adminOnly, refuses everyone if none is set,
+// for the pages that change what is stored.
+func adminRequired() gin.HandlerFunc {
+	if app.AdminPassword == "" {
+		return func(c *gin.Context) {
+			c.String(http.StatusForbidden, "forbidden: set ADMIN_PASSWORD to enable this page")
+			c.Abort()
+		}
+	}
+	return gin.BasicAuth(gin.Accounts{"admin": app.AdminPassword})
+}
+
+// absoluteURL returns the URL of the path on this server, as seen by the client behind a proxy.
+func absoluteURL(c *gin.Context, path string) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, c.Request.Host, path)
+}
+
+// remoteHost returns the address of the peer of the request. Unlike the client IP of gin, it is not
+// taken from X-Forwarded-For, which any client can set.
+func remoteHost(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
+// exportFilename names the download of the results of the current session of the game.
+func exportFilename(c *gin.Context, game *app.Game, suffix string) string {
+	name := c.Param("id")
+	if game.LastStartedAt != nil {
+		name += "-" + game.LastStartedAt.Format("20060102")
+	}
+	return fmt.Sprintf("attachment; filename=%q", name+suffix)
+}
+
 func getPool() *app.Pool {
 	pool := app.NewPool()
 	go pool.Run()
+	go app.NewScheduler(pool).Run()
 	return pool
 }
 
@@ -22,22 +82,31 @@ func getRouter(pool *app.Pool) *gin.Engine {
 	r := gin.Default()
 	r.HandleMethodNotAllowed = true
 
-	renderer := multitemplate.NewRenderer()
-	renderer.AddFromFiles("index", "templates/index.html")
-	renderer.AddFromFiles("games", "templates/index.html", "templates/games.html")
-	renderer.AddFromFiles("play", "templates/index.html", "templates/play.html")
-	renderer.AddFromFiles("find_cat", "templates/index.html", "templates/find_cat.html")
-	renderer.AddFromFilesFuncs("podium", template.FuncMap{
+	jsonFuncs := template.FuncMap{
 		"json": func(v interface{}) template.JS {
 			bytes, _ := json.Marshal(v)
 			return template.JS(bytes)
 		},
-	}, "templates/index.html", "templates/podium.html")
-	renderer.AddFromFilesFuncs("scores", template.FuncMap{
+	}
+	addFuncs := template.FuncMap{
 		"add": func(v int, i int) int {
 			return v + i
 		},
-	}, "templates/index.html", "templates/scores.html")
+	}
+
+	renderer := multitemplate.NewRenderer()
+	renderer.AddFromFiles("index", "templates/index.html")
+	renderer.AddFromFiles("games", "templates/index.html", "templates/games.html")
+	renderer.AddFromFiles("play", "templates/index.html", "templates/play.html")
+	renderer.AddFromFiles("present", "templates/index.html", "templates/present.html")
+	renderer.AddFromFiles("join", "templates/index.html", "templates/join.html")
+	renderer.AddFromFiles("import", "templates/index.html", "templates/import.html")
+	renderer.AddFromFilesFuncs("find_cat", jsonFuncs, "templates/index.html", "templates/find_cat.html")
+	renderer.AddFromFilesFuncs("podium", jsonFuncs, "templates/index.html", "templates/podium.html")
+	renderer.AddFromFilesFuncs("self_paced", addFuncs, "templates/index.html", "templates/self_paced.html")
+	renderer.AddFromFilesFuncs("scores", addFuncs, "templates/index.html", "templates/scores.html")
+	renderer.AddFromFilesFuncs("replay", jsonFuncs, "templates/index.html", "templates/replay.html")
+	renderer.AddFromFiles("rescore", "templates/index.html", "templates/rescore.html")
 	r.HTMLRender = renderer
 
 	r.Static("/static", "./static/")
@@ -50,6 +119,14 @@ func getRouter(pool *app.Pool) *gin.Engine {
 	r.GET("/", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "index", nil)
 	})
+	r.GET("/protocol/schema.json", func(c *gin.Context) {
+		schema, err := protocol.Schema()
+		if err != nil {
+			panic(err)
+		}
+		c.Data(http.StatusOK, "application/schema+json", schema)
+	})
+
 	r.GET("/games", func(c *gin.Context) {
 		type Game struct {
 			ID    string `json:"id"`
@@ -72,6 +149,68 @@ func getRouter(pool *app.Pool) *gin.Engine {
 		c.HTML(http.StatusOK, "games", ctx)
 	})
 
+	r.GET("/games/import", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "import", gin.H{"type": app.GameTypeQuiz, "mode": "", "title": "", "author": ""})
+	})
+	r.POST("/games/import", func(c *gin.Context) {
+		base := app.Game{
+			Type:   c.PostForm("type"),
+			Mode:   c.PostForm("mode"),
+			Title:  app.StripHtmlTags(c.PostForm("title")),
+			Author: app.StripHtmlTags(c.PostForm("author")),
+		}
+		ctx := gin.H{"type": base.Type, "mode": base.Mode, "title": base.Title, "author": base.Author}
+
+		header, err := c.FormFile("file")
+		if err != nil {
+			ctx["errors"] = []string{"choose a file to import"}
+			c.HTML(http.StatusBadRequest, "import", ctx)
+			return
+		}
+		format := c.PostForm("format")
+		if format == "" {
+			format = app.QuizFormat(header.Filename)
+		}
+		file, err := header.Open()
+		if err != nil {
+			panic(err)
+		}
+		defer func() { _ = file.Close() }()
+
+		game, err := app.ImportQuiz(format, file, base)
+		if err != nil {
+			if errs, ok := err.(app.ImportErrors); ok {
+				messages := make([]string, len(errs))
+				for i, e := range errs {
+					messages[i] = e.Error()
+				}
+				ctx["errors"] = messages
+			} else {
+				ctx["errors"] = []string{err.Error()}
+			}
+			c.HTML(http.StatusUnprocessableEntity, "import", ctx)
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/play/"+app.GameHashID.Encode(game.ID))
+	})
+
+	r.GET("/join", func(c *gin.Context) {
+		pin := c.Query("pin")
+		if pin == "" {
+			c.HTML(http.StatusOK, "join", gin.H{})
+			return
+		}
+		id, err := app.JoinPins.Resolve(pin, remoteHost(c))
+		switch err {
+		case nil:
+			c.Redirect(http.StatusTemporaryRedirect, "/play/"+app.GameHashID.Encode(id))
+		case app.ErrPinThrottled:
+			c.HTML(http.StatusTooManyRequests, "join", gin.H{"pin": pin, "throttled": true})
+		default:
+			c.HTML(http.StatusOK, "join", gin.H{"pin": pin, "invalid": true})
+		}
+	})
+
 	rp := r.Group("/play/:id", func(c *gin.Context) {
 		if game := app.GetGameByHash(c.Param("id")); game != nil {
 			c.Set("game", game)
@@ -82,51 +221,393 @@ func getRouter(pool *app.Pool) *gin.Engine {
 	})
 	rp.GET("", func(c *gin.Context) {
 		game := c.MustGet("game").(*app.Game)
-		switch game.Type {
-		case app.GameTypeFindCat:
-			app.FindCat(c)
-		default:
+		switch {
+		case game.IsLive():
 			c.HTML(http.StatusOK, "play", gin.H{
-				"title":   game.Title,
-				"wireURL": c.Request.URL.Path + "/wire",
+				"title":      game.Title,
+				"teams":      game.Options.Teams,
+				"wireURL":    c.Request.URL.Path + "/wire",
+				"presentURL": c.Request.URL.Path + "/present",
 			})
+		case game.Type == app.GameTypeFindCat:
+			app.FindCat(c)
+		default:
+			app.SelfPaced(c)
 		}
 	})
 	rp.POST("", func(c *gin.Context) {
 		game := c.MustGet("game").(*app.Game)
-		switch game.Type {
-		case app.GameTypeFindCat:
+		switch {
+		case game.IsLive():
+			c.AbortWithStatus(http.StatusMethodNotAllowed)
+		case game.Type == app.GameTypeFindCat:
 			app.FindCat(c)
 		default:
-			c.AbortWithStatus(http.StatusMethodNotAllowed)
+			app.SelfPaced(c)
 		}
 	})
 	rp.GET("/wire", func(c *gin.Context) {
 		game := c.MustGet("game").(*app.Game)
+		if !game.IsLive() {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
 		player := &app.Player{
-			Game: game,
-			Name: app.StripHtmlTags(c.Query("player")),
+			Game:        game,
+			Name:        app.StripHtmlTags(c.Query("player")),
+			Team:        c.Query("team"),
+			IsSpectator: c.Query("presenter") != "",
 		}
-		if player.Name == "" {
+		if player.Name == "" && !player.IsSpectator {
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
-		if player.Name == game.Author {
+		if player.Name == game.Author && !player.IsSpectator {
 			player.IsAuthor = true
 		}
 		app.WireHandler(pool, player, c.Writer, c.Request)
 	})
+	rp.GET("/present", func(c *gin.Context) {
+		game := c.MustGet("game").(*app.Game)
+		if !game.IsLive() {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		c.HTML(http.StatusOK, "present", gin.H{
+			"title":   game.Title,
+			"joinURL": absoluteURL(c, "/join"),
+			"qrURL":   "/play/" + c.Param("id") + "/qr.png",
+			"wireURL": "/play/" + c.Param("id") + "/wire",
+		})
+	})
+	rp.GET("/qr.png", func(c *gin.Context) {
+		png, err := qrcode.Encode(absoluteURL(c, "/play/"+c.Param("id")), qrcode.Medium, qrCodeSize)
+		if err != nil {
+			panic(err)
+		}
+		c.Data(http.StatusOK, "image/png", png)
+	})
 	rp.GET("/scores", func(c *gin.Context) {
 		game := c.MustGet("game").(*app.Game)
 		c.HTML(http.StatusOK, "scores", gin.H{
-			"game":   game,
-			"scores": app.GetScores(game),
+			"game":      game,
+			"scores":    app.GetScores(game),
+			"exportURL": "/play/" + c.Param("id") + "/scores",
+		})
+	})
+	rp.GET("/quiz.json", func(c *gin.Context) {
+		game := c.MustGet("game").(*app.Game)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", c.Param("id")+".json"))
+		c.JSON(http.StatusOK, app.ExportQuiz(game))
+	})
+	rp.GET("/scores.csv", func(c *gin.Context) {
+		game := c.MustGet("game").(*app.Game)
+		summary, answers := app.SessionResults(game)
+		sheet := summary
+		if c.Query("sheet") == "answers" {
+			sheet = answers
+		}
+		c.Header("Content-Disposition", exportFilename(c, game, "-"+strings.ToLower(sheet.Name)+".csv"))
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		if err := sheet.WriteCSV(c.Writer); err != nil {
+			panic(err)
+		}
+	})
+	rp.GET("/scores.xlsx", func(c *gin.Context) {
+		game := c.MustGet("game").(*app.Game)
+		summary, answers := app.SessionResults(game)
+		c.Header("Content-Disposition", exportFilename(c, game, ".xlsx"))
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		if err := app.WriteXLSX(c.Writer, summary, answers); err != nil {
+			panic(err)
+		}
+	})
+	rp.GET("/replay", func(c *gin.Context) {
+		game := c.MustGet("game").(*app.Game)
+		sessions := app.GetSessions(game)
+		if len(sessions) == 0 {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		session := sessions[0]
+		if s := c.Query("session"); s != "" {
+			nsec, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			session = time.Unix(0, nsec).In(session.Location())
+		}
+		events := app.GetEvents(game, session)
+		if len(events) == 0 {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		recorded, recomputed, err := app.ReplayScores(game, events)
+		errMessage := ""
+		if err != nil {
+			errMessage = err.Error()
+		}
+		types := make(map[protocol.MessageType]string, len(protocol.Messages))
+		for _, spec := range protocol.Messages {
+			types[spec.Type] = spec.Name
+		}
+		c.HTML(http.StatusOK, "replay", gin.H{
+			"game":       game,
+			"session":    session,
+			"sessions":   sessions,
+			"events":     events,
+			"types":      types,
+			"tasks":      app.ReplayTasks(game, events),
+			"recorded":   recorded,
+			"recomputed": recomputed,
+			"error":      errMessage,
 		})
 	})
+	rp.GET("/rescore", adminOnly(), func(c *gin.Context) {
+		game := c.MustGet("game").(*app.Game)
+		r, err := app.RescoreGame(game)
+		if err != nil {
+			c.String(http.StatusConflict, "cannot rescore: %v", err)
+			return
+		}
+		c.HTML(http.StatusOK, "rescore", gin.H{
+			"game":    game,
+			"rescore": r,
+		})
+	})
+	rp.POST("/rescore", adminRequired(), func(c *gin.Context) {
+		game := c.MustGet("game").(*app.Game)
+		r, err := app.RescoreGame(game)
+		if err != nil {
+			c.String(http.StatusConflict, "cannot rescore: %v", err)
+			return
+		}
+		if c.PostForm("digest") != r.Digest() {
+			c.String(http.StatusConflict, "cannot rescore: the scores changed since they were reviewed, review them again")
+			return
+		}
+		r.Apply()
+		c.Redirect(http.StatusSeeOther, "/play/"+c.Param("id")+"/scores")
+	})
 	return r
 }
 
+func validate() {
+	valid := true
+	for _, game := range app.GetGames() {
+		if err := game.Options.Validate(); err != nil {
+			fmt.Printf("%s (%s), options: %v\n", game.Title, app.GameHashID.Encode(game.ID), err)
+			valid = false
+		}
+		for i, task := range game.GetTasks() {
+			if err := task.Validate(game.Type); err != nil {
+				fmt.Printf("%s (%s), task %d: %v\n", game.Title, app.GameHashID.Encode(game.ID), i+1, err)
+				valid = false
+			}
+		}
+	}
+	if !valid {
+		os.Exit(1)
+	}
+}
+
+func rescore(args []string) {
+	flags := flag.NewFlagSet("rescore", flag.ExitOnError)
+	hash := flags.String("game", "", "hash ID of the game to rescore")
+	apply := flags.Bool("apply", false, "store the recomputed scores")
+	_ = flags.Parse(args)
+
+	if *hash == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	game := app.GetGameByHash(*hash)
+	if game == nil {
+		log.Fatalf("no game %q", *hash)
+	}
+	r, err := app.RescoreGame(game)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PLACE\tPLAYER\tBEFORE\tAFTER\t")
+	for _, change := range r.Leaderboard {
+		mark := ""
+		if change.Changed() {
+			mark = "*"
+		}
+		_, _ = fmt.Fprintf(w, "%d -> %d\t%s\t%.2f\t%.2f\t%s\n",
+			change.BeforePlace, change.AfterPlace, change.Player, change.Before, change.After, mark)
+	}
+	_ = w.Flush()
+
+	switch {
+	case len(r.Changes) == 0:
+		fmt.Println("no scores change")
+	case *apply:
+		r.Apply()
+		fmt.Printf("%d scores changed\n", len(r.Changes))
+	default:
+		fmt.Printf("%d scores would change, run with -apply to store them\n", len(r.Changes))
+	}
+}
+
+func importQuiz(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "json, csv or gift, by the file extension if empty")
+	gameType := flags.String("type", app.GameTypeQuiz, "type of the game, for csv and gift")
+	mode := flags.String("mode", "", "mode of the game, for csv and gift")
+	title := flags.String("title", "", "title of the game, replaces the one of a json file")
+	author := flags.String("author", "", "author of the game, replaces the one of a json file")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		_, _ = fmt.Fprintln(flags.Output(), "usage: kakadoo import [flags] file")
+		flags.PrintDefaults()
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = app.QuizFormat(flags.Arg(0))
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	game, err := app.ImportQuiz(*format, file, app.Game{Type: *gameType, Mode: *mode, Title: *title, Author: *author})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s (%s), %d tasks\n", game.Title, app.GameHashID.Encode(game.ID), len(game.GetTasks()))
+}
+
+func exportQuiz(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	hash := flags.String("game", "", "hash ID of the game to export")
+	_ = flags.Parse(args)
+
+	game := app.GetGameByHash(*hash)
+	if game == nil {
+		log.Fatalf("no game %q", *hash)
+	}
+	out, err := json.MarshalIndent(app.ExportQuiz(game), "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(out))
+}
+
+func bank(args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: kakadoo bank import|list|compose [flags]")
+	}
+	flags := flag.NewFlagSet("bank "+args[0], flag.ExitOnError)
+	category := flags.String("category", "", "category of the questions")
+	difficulty := flags.String("difficulty", "", "difficulty of the questions: easy, medium or hard")
+	tags := flags.String("tags", "", "comma separated tags of the questions, any of which match")
+
+	var labels app.QuestionLabels
+	parse := func() {
+		_ = flags.Parse(args[1:])
+		labels = app.QuestionLabels{Category: *category, Difficulty: *difficulty}
+		for _, tag := range strings.Split(*tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				labels.Tags = append(labels.Tags, tag)
+			}
+		}
+	}
+
+	switch args[0] {
+	case "import":
+		format := flags.String("format", "", "json, csv or gift, by the file extension if empty")
+		gameType := flags.String("type", app.GameTypeQuiz, "type of the games the questions are for")
+		parse()
+		if flags.NArg() != 1 {
+			_, _ = fmt.Fprintln(flags.Output(), "usage: kakadoo bank import [flags] file")
+			flags.PrintDefaults()
+			os.Exit(2)
+		}
+		if *format == "" {
+			*format = app.QuizFormat(flags.Arg(0))
+		}
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() { _ = file.Close() }()
+
+		questions, err := app.ImportQuestions(*format, file, *gameType, labels)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d questions\n", len(questions))
+	case "list":
+		parse()
+		filter := app.QuestionFilter{Tags: labels.Tags, Category: labels.Category, Difficulty: labels.Difficulty}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "ID\tCATEGORY\tDIFFICULTY\tTAGS\tQUESTION\t")
+		for _, q := range app.GetQuestions(filter) {
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t\n",
+				q.ID, q.Category, q.Difficulty, strings.Join(q.Tags, ","), q.Question)
+		}
+		_ = w.Flush()
+	case "compose":
+		count := flags.Int("count", 10, "number of questions to draw")
+		gameType := flags.String("type", app.GameTypeQuiz, "type of the game")
+		mode := flags.String("mode", "", "mode of the game")
+		title := flags.String("title", "", "title of the game")
+		author := flags.String("author", "", "author of the game")
+		parse()
+		filter := app.QuestionFilter{Tags: labels.Tags, Category: labels.Category, Difficulty: labels.Difficulty}
+		game, err := app.ComposeGame(app.Game{Type: *gameType, Mode: *mode, Title: *title, Author: *author},
+			filter, *count)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s (%s), %d tasks drawing %s\n", game.Title, app.GameHashID.Encode(game.ID), *count,
+			filter.String())
+	default:
+		log.Fatalf("unknown bank command %q", args[0])
+	}
+}
+
+func schema() {
+	schema, err := protocol.Schema()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(schema))
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			validate()
+		case "schema":
+			schema()
+		case "loadtest":
+			loadtest(os.Args[2:])
+		case "rescore":
+			rescore(os.Args[2:])
+		case "import":
+			importQuiz(os.Args[2:])
+		case "export":
+			exportQuiz(os.Args[2:])
+		case "bank":
+			bank(os.Args[2:])
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+		return
+	}
+
 	pool := getPool()
 	router := getRouter(pool)
 
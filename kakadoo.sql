@@ -1,23 +1,64 @@
 CREATE TYPE game_type AS ENUM ('quiz', 'woc', 'find_cat');
+CREATE TYPE game_mode AS ENUM ('live', 'self_paced');
 
 CREATE TABLE games (
     id serial NOT NULL CONSTRAINT games_pk PRIMARY KEY,
     type game_type NOT NULL,
+    mode game_mode,
     title varchar(128) NOT NULL,
     author varchar(32) NOT NULL,
+    last_started_at timestamp,
+    opens_at timestamp,
+    closes_at timestamp,
+    scheduled_at timestamp,
+    task_gap integer DEFAULT 5 NOT NULL,
+    options jsonb DEFAULT '{}' NOT NULL,
     created_at timestamp DEFAULT current_timestamp NOT NULL
 );
 
+CREATE TYPE question_difficulty AS ENUM ('easy', 'medium', 'hard');
+
+CREATE TABLE questions (
+    id serial NOT NULL CONSTRAINT questions_pk PRIMARY KEY,
+    question varchar NOT NULL,
+    answers varchar[] DEFAULT '{}' NOT NULL,
+    image boolean DEFAULT false NOT NULL,
+    correct_answer varchar NOT NULL,
+    time_to_answer integer DEFAULT 10 NOT NULL,
+    multiplier double precision DEFAULT 1 NOT NULL,
+    allow_change boolean DEFAULT false NOT NULL,
+    category varchar DEFAULT '' NOT NULL,
+    difficulty question_difficulty,
+    tags varchar[] DEFAULT '{}' NOT NULL,
+    created_at timestamp DEFAULT current_timestamp NOT NULL
+);
+
+CREATE INDEX questions_tags_index ON questions USING gin (tags);
+
 CREATE TABLE tasks (
     id serial NOT NULL CONSTRAINT tasks_pk PRIMARY KEY,
     game_id integer NOT NULL CONSTRAINT tasks_games_id_fk REFERENCES games ON UPDATE CASCADE ON DELETE CASCADE,
-    question varchar NOT NULL,
+    question_id integer CONSTRAINT tasks_questions_id_fk REFERENCES questions ON UPDATE CASCADE ON DELETE RESTRICT,
+    draw jsonb,
+    question varchar DEFAULT '' NOT NULL,
     answers varchar[] DEFAULT '{}' NOT NULL,
-    correct_answer varchar NOT NULL,
+    image boolean DEFAULT false NOT NULL,
+    correct_answer varchar DEFAULT '' NOT NULL,
     time_to_answer integer DEFAULT 10 NOT NULL,
+    multiplier double precision DEFAULT 1 NOT NULL,
+    allow_change boolean DEFAULT false NOT NULL,
     created_at timestamp DEFAULT current_timestamp NOT NULL
 );
 
+CREATE TABLE draws (
+    id serial NOT NULL CONSTRAINT draws_pk PRIMARY KEY,
+    task_id integer NOT NULL CONSTRAINT draws_tasks_id_fk REFERENCES tasks ON UPDATE CASCADE ON DELETE CASCADE,
+    session timestamp NOT NULL,
+    question_id integer NOT NULL CONSTRAINT draws_questions_id_fk REFERENCES questions ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX draws_task_id_session_uindex ON draws (task_id, session);
+
 CREATE TABLE scores (
     id serial NOT NULL CONSTRAINT log_pk PRIMARY KEY,
     game_id integer NOT NULL CONSTRAINT log_games_id_fk REFERENCES games ON UPDATE CASCADE ON DELETE SET NULL,
@@ -29,3 +70,25 @@ CREATE TABLE scores (
     score double precision NOT NULL,
     created_at timestamp DEFAULT current_timestamp NOT NULL
 );
+
+CREATE TABLE deliveries (
+    id serial NOT NULL CONSTRAINT deliveries_pk PRIMARY KEY,
+    game_id integer NOT NULL CONSTRAINT deliveries_games_id_fk REFERENCES games ON UPDATE CASCADE ON DELETE CASCADE,
+    task_id integer NOT NULL CONSTRAINT deliveries_tasks_id_fk REFERENCES tasks ON UPDATE CASCADE ON DELETE CASCADE,
+    player varchar NOT NULL,
+    player_key varchar NOT NULL,
+    delivered_at timestamp DEFAULT current_timestamp NOT NULL
+);
+
+CREATE TABLE events (
+    id serial NOT NULL CONSTRAINT events_pk PRIMARY KEY,
+    game_id integer NOT NULL CONSTRAINT events_games_id_fk REFERENCES games ON UPDATE CASCADE ON DELETE CASCADE,
+    session timestamp NOT NULL,
+    seq integer NOT NULL,
+    type integer NOT NULL,
+    player varchar NOT NULL,
+    data jsonb,
+    created_at timestamp NOT NULL
+);
+
+CREATE UNIQUE INDEX events_game_id_session_seq_uindex ON events (game_id, session, seq);
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lokhman/kakadoo/app"
+	"github.com/lokhman/kakadoo/client"
+	"github.com/lokhman/kakadoo/protocol"
+)
+
+type loadtestConfig struct {
+	url           string
+	hash          string
+	quiz          *app.QuizFile
+	tasks         map[string]*app.QuizFileTask
+	players       int
+	ramp          time.Duration
+	latency       time.Duration
+	latencyStdDev time.Duration
+	correct       float64
+	pause         time.Duration
+	timeout       time.Duration
+}
+
+// loadtestStats collects the measurements of all simulated clients.
+type loadtestStats struct {
+	taskSentAt   map[int]time.Time
+	taskLatency  []time.Duration
+	finishSpread map[int][]time.Time
+	answerAck    []time.Duration
+	accepted     int
+	rejected     map[string]int
+	connected    int
+	connectErrs  []error
+	dropped      []string
+	started      time.Time
+	finished     time.Time
+	mu           sync.Mutex
+}
+
+func (s *loadtestStats) taskSent(index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.taskSentAt[index] = time.Now()
+}
+
+func (s *loadtestStats) taskReceived(index int, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sentAt, ok := s.taskSentAt[index]; ok {
+		s.taskLatency = append(s.taskLatency, at.Sub(sentAt))
+	}
+}
+
+func (s *loadtestStats) taskFinished(index int, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.finishSpread[index] = append(s.finishSpread[index], at)
+}
+
+func (s *loadtestStats) answered(sentAt time.Time, event client.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch data := event.Data.(type) {
+	case protocol.AnswerAcceptedData:
+		s.accepted++
+		s.answerAck = append(s.answerAck, event.Received.Sub(sentAt))
+	case protocol.AnswerRejectedData:
+		s.rejected[data.Reason]++
+	}
+}
+
+func (s *loadtestStats) connect(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.connectErrs = append(s.connectErrs, err)
+	} else {
+		s.connected++
+	}
+}
+
+func (s *loadtestStats) drop(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		name += ": " + err.Error()
+	}
+	s.dropped = append(s.dropped, name)
+}
+
+// loadtestTaskKey identifies a task by its question and answers, which are sent in the order of the
+// player if the game shuffles them.
+func loadtestTaskKey(question string, answers []string) string {
+	sorted := append([]string(nil), answers...)
+	sort.Strings(sorted)
+	return question + "\x00" + strings.Join(sorted, "\x00")
+}
+
+// loadQuiz reads the game in the native JSON format from the file, if given, or downloads it from
+// the server, so that production can be tested without access to its database.
+func loadQuiz(url string, hash string, filename string) (*app.QuizFile, error) {
+	var qf app.QuizFile
+	if filename != "" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		return &qf, json.NewDecoder(f).Decode(&qf)
+	}
+
+	resp, err := http.Get(strings.TrimSuffix(url, "/") + "/play/" + hash + "/quiz.json")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("quiz.json: %s", resp.Status)
+	}
+	return &qf, json.NewDecoder(resp.Body).Decode(&qf)
+}
+
+// answer picks the answer of a simulated player to the task, correct with the given probability.
+// Questions drawn from the bank are not in the quiz, so they are answered at random.
+func (c *loadtestConfig) answer(data protocol.TaskData) string {
+	correct := rand.Float64() < c.correct
+
+	task := c.tasks[loadtestTaskKey(data.Question, data.Answers)]
+	if task == nil {
+		if len(data.Answers) > 0 && !data.Image {
+			return data.Answers[rand.Intn(len(data.Answers))]
+		}
+		return "-"
+	}
+	if len(task.Answers) > 0 && !data.Image {
+		if correct || len(task.Answers) == 1 {
+			return task.CorrectAnswer
+		}
+		for {
+			if answer := task.Answers[rand.Intn(len(task.Answers))]; answer != task.CorrectAnswer {
+				return answer
+			}
+		}
+	}
+	if regions, err := app.ParseRegions(task.CorrectAnswer); err == nil {
+		if !correct || len(regions.Regions) == 0 {
+			return "-1,-1"
+		}
+		// the centroid of the points of the region, which is inside any rectangle or circle
+		var x, y float64
+		points := regions.Regions[0].Points
+		for _, point := range points {
+			x += point.X / float64(len(points))
+			y += point.Y / float64(len(points))
+		}
+		return fmt.Sprintf("%g,%g", x, y)
+	}
+	if value, err := strconv.ParseFloat(task.CorrectAnswer, 64); err == nil {
+		if !correct {
+			value *= 0.5 + rand.Float64()
+		}
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	if correct {
+		return task.CorrectAnswer
+	}
+	return "-"
+}
+
+func (c *loadtestConfig) delay() time.Duration {
+	d := time.Duration(rand.NormFloat64()*float64(c.latencyStdDev)) + c.latency
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func loadtestPlayer(ctx context.Context, config *loadtestConfig, stats *loadtestStats, name string) {
+	c, err := client.Dial(ctx, client.Config{URL: config.url, Game: config.hash, Player: name})
+	stats.connect(err)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	var sentAt time.Time
+	var mu sync.Mutex
+	for {
+		var event client.Event
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-c.Events():
+			if !ok {
+				stats.drop(name, c.Err())
+				return
+			}
+			event = e
+		}
+
+		switch data := event.Data.(type) {
+		case protocol.TaskData:
+			stats.taskReceived(data.Index, event.Received)
+			answer := config.answer(data)
+			time.AfterFunc(config.delay(), func() {
+				mu.Lock()
+				sentAt = time.Now()
+				mu.Unlock()
+				_ = c.Answer(answer)
+			})
+		case protocol.AnswerAcceptedData, protocol.AnswerRejectedData:
+			mu.Lock()
+			stats.answered(sentAt, event)
+			mu.Unlock()
+		case protocol.TaskFinishedData:
+			stats.taskFinished(data.Index, event.Received)
+		case protocol.GameFinishedData:
+			return
+		}
+		if event.Type == protocol.PlayerExists || event.Type == protocol.GameFull ||
+			event.Type == protocol.GameInProgress {
+			stats.drop(name, fmt.Errorf("rejected with %v", event.Type))
+			return
+		}
+	}
+}
+
+// loadtestHost starts the game once the players have joined and moves on to the next task as soon as
+// the previous one is finished.
+func loadtestHost(ctx context.Context, config *loadtestConfig, stats *loadtestStats, joined <-chan struct{}) error {
+	c, err := client.Dial(ctx, client.Config{URL: config.url, Game: config.hash, Player: config.quiz.Author})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	numTasks, nextIndex := 0, 0
+	next := func(index int) error {
+		if index >= numTasks {
+			stats.finished = time.Now()
+			return c.Finish()
+		}
+		stats.taskSent(index)
+		return c.Next()
+	}
+	// the host keeps reading while it waits, or the server would drop it for falling behind
+	var start <-chan struct{}
+	var pause <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-start:
+			start = nil
+			stats.started = time.Now()
+			if err := c.Start(); err != nil {
+				return err
+			}
+		case <-pause:
+			pause = nil
+			if err := next(nextIndex); err != nil {
+				return err
+			}
+		case event, ok := <-c.Events():
+			if !ok {
+				return fmt.Errorf("host disconnected: %v", c.Err())
+			}
+			switch data := event.Data.(type) {
+			case protocol.ReadyData:
+				if data.State != 0 {
+					return fmt.Errorf("the game is already on")
+				}
+				start = joined
+			case protocol.GameStartedData:
+				numTasks = data.NumTasks
+				if err := next(0); err != nil {
+					return err
+				}
+			case protocol.TaskFinishedData:
+				nextIndex = data.Index + 1
+				pause = time.After(config.pause)
+			case protocol.GameFinishedData:
+				return nil
+			}
+		}
+	}
+}
+
+func percentiles(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "n/a"
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	at := func(p float64) time.Duration {
+		return durations[int(math.Ceil(p*float64(len(durations))))-1].Round(time.Millisecond)
+	}
+	return fmt.Sprintf("p50 %v, p95 %v, p99 %v, max %v", at(0.5), at(0.95), at(0.99), at(1))
+}
+
+func (s *loadtestStats) report() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Printf("players:           %d connected, %d failed to connect, %d dropped\n",
+		s.connected, len(s.connectErrs), len(s.dropped))
+	for i, err := range s.connectErrs {
+		if i == 10 {
+			fmt.Printf("  ... and %d more\n", len(s.connectErrs)-i)
+			break
+		}
+		fmt.Printf("  connect: %v\n", err)
+	}
+	for i, name := range s.dropped {
+		if i == 10 {
+			fmt.Printf("  ... and %d more\n", len(s.dropped)-i)
+			break
+		}
+		fmt.Printf("  dropped: %s\n", name)
+	}
+
+	fmt.Printf("task broadcast:    %s\n", percentiles(s.taskLatency))
+	spreads := make([]time.Duration, 0, len(s.finishSpread))
+	for _, times := range s.finishSpread {
+		first, last := times[0], times[0]
+		for _, t := range times {
+			if t.Before(first) {
+				first = t
+			}
+			if t.After(last) {
+				last = t
+			}
+		}
+		spreads = append(spreads, last.Sub(first))
+	}
+	fmt.Printf("results spread:    %s\n", percentiles(spreads))
+	fmt.Printf("answer ack:        %s\n", percentiles(s.answerAck))
+
+	elapsed := s.finished.Sub(s.started)
+	throughput := 0.0
+	if elapsed > 0 {
+		throughput = float64(s.accepted) / elapsed.Seconds()
+	}
+	fmt.Printf("answers:           %d accepted (%.1f/s over %v), rejected %v\n",
+		s.accepted, throughput, elapsed.Round(time.Second), s.rejected)
+}
+
+func loadtest(args []string) {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:"+app.GetEnv("PORT", "8080"), "base URL of the server")
+	hash := flags.String("game", "", "hash ID of the live game to play")
+	quiz := flags.String("quiz", "", "exported game with the correct answers, downloaded from the server if not given")
+	players := flags.Int("players", 100, "number of simulated players")
+	ramp := flags.Duration("ramp", 10*time.Second, "time to connect all players over")
+	latency := flags.Duration("latency", 3*time.Second, "mean time players take to answer")
+	latencyStdDev := flags.Duration("latency-stddev", time.Second, "standard deviation of the time to answer")
+	correct := flags.Float64("correct", 0.6, "probability of a correct answer")
+	pause := flags.Duration("pause", 2*time.Second, "pause of the host between tasks")
+	timeout := flags.Duration("timeout", 30*time.Minute, "time limit of the whole test")
+	_ = flags.Parse(args)
+
+	if *hash == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *correct < 0 || *correct > 1 {
+		log.Fatal("correct must be between 0 and 1")
+	}
+	qf, err := loadQuiz(*url, *hash, *quiz)
+	if err != nil {
+		log.Fatalf("game %q: %v", *hash, err)
+	}
+	if game := (&app.Game{Type: qf.Type, Mode: qf.Mode}); !game.IsLive() {
+		log.Fatalf("game %q is not live", *hash)
+	}
+	config := &loadtestConfig{
+		url:           *url,
+		hash:          *hash,
+		quiz:          qf,
+		tasks:         make(map[string]*app.QuizFileTask),
+		players:       *players,
+		ramp:          *ramp,
+		latency:       *latency,
+		latencyStdDev: *latencyStdDev,
+		correct:       *correct,
+		pause:         *pause,
+		timeout:       *timeout,
+	}
+	for i := range qf.Tasks {
+		task := &qf.Tasks[i]
+		config.tasks[loadtestTaskKey(task.Question, task.Answers)] = task
+	}
+	stats := &loadtestStats{
+		taskSentAt:   make(map[int]time.Time),
+		finishSpread: make(map[int][]time.Time),
+		rejected:     make(map[string]int),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.timeout)
+	defer cancel()
+
+	joined := make(chan struct{})
+	host := make(chan error, 1)
+	go func() {
+		host <- loadtestHost(ctx, config, stats, joined)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.players; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			loadtestPlayer(ctx, config, stats, name)
+		}(fmt.Sprintf("loadtest-%04d", i+1))
+		if config.players > 1 {
+			time.Sleep(config.ramp / time.Duration(config.players-1))
+		}
+	}
+	// give the last players a moment to be registered before the game starts
+	time.Sleep(time.Second)
+	close(joined)
+
+	if err := <-host; err != nil {
+		log.Printf("host: %v", err)
+		cancel()
+	}
+	wg.Wait()
+	stats.report()
+}
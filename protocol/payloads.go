@@ -0,0 +1,134 @@
+package protocol
+
+import "time"
+
+type Player struct {
+	Name        string `json:"name"`
+	Team        string `json:"team,omitempty"`
+	IsAuthor    bool   `json:"is_author"`
+	IsBaseline  bool   `json:"is_baseline,omitempty"`
+	IsSpectator bool   `json:"is_spectator,omitempty"`
+}
+
+// ReadyData is sent to the player who has joined the game. State is 0 before the game starts.
+type ReadyData struct {
+	Version     int        `json:"version"`
+	Name        string     `json:"name"`
+	IsSpectator bool       `json:"is_spectator"`
+	Players     []Player   `json:"players"`
+	Ready       []string   `json:"ready"`
+	Type        string     `json:"gp_type"`
+	State       int        `json:"gp_state"`
+	NumTasks    int        `json:"gp_num_tasks"`
+	StartsAt    *time.Time `json:"gp_starts_at"`
+	Teams       []string   `json:"gp_teams"`
+	PowerUps    []string   `json:"gp_power_ups"`
+	MaxPlayers  int        `json:"gp_max_players"`
+	Pin         string     `json:"gp_pin"`
+}
+
+type GameStartedData struct {
+	NumTasks int `json:"num_tasks"`
+}
+
+// TaskData is sent when a task starts. Image tasks are answered with the "x,y" coordinates of
+// a click on the image in Question.
+type TaskData struct {
+	Index        int      `json:"index"`
+	Question     string   `json:"question"`
+	Answers      []string `json:"answers"`
+	Image        bool     `json:"image"`
+	TimeToAnswer int      `json:"time_to_answer"`
+	AllowChange  bool     `json:"allow_change"`
+}
+
+type Score struct {
+	Player string  `json:"player"`
+	Team   string  `json:"team,omitempty"`
+	Score  float64 `json:"score"`
+}
+
+type TeamScore struct {
+	Team    string  `json:"team"`
+	Players int     `json:"players"`
+	Score   float64 `json:"score"`
+}
+
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type Region struct {
+	Type   string  `json:"type"`
+	Points []Point `json:"points"`
+	Radius float64 `json:"radius,omitempty"`
+}
+
+type Bin struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int     `json:"count"`
+}
+
+type Estimate struct {
+	Player   string  `json:"player"`
+	Estimate float64 `json:"estimate"`
+}
+
+// Distribution summarises the estimates of a woc task. Scale is "linear" or "log".
+type Distribution struct {
+	Scale         string             `json:"scale"`
+	CorrectAnswer float64            `json:"correct_answer"`
+	Bins          []Bin              `json:"bins"`
+	Baselines     map[string]float64 `json:"baselines"`
+	Estimates     []Estimate         `json:"estimates"`
+}
+
+// TaskFinishedData is sent when a task ends. Regions and Heatmap are only sent for image tasks
+// and Distribution only for woc tasks.
+type TaskFinishedData struct {
+	Index         int            `json:"index"`
+	CorrectAnswer string         `json:"correct_answer"`
+	Stats         map[string]int `json:"stats"`
+	Scores        []Score        `json:"scores"`
+	Teams         []TeamScore    `json:"teams"`
+	Streaks       map[string]int `json:"streaks"`
+	Regions       []Region       `json:"regions,omitempty"`
+	Heatmap       []Point        `json:"heatmap,omitempty"`
+	Distribution  *Distribution  `json:"distribution,omitempty"`
+}
+
+type GameFinishedData struct {
+	Scores []Score     `json:"scores"`
+	Teams  []TeamScore `json:"teams"`
+}
+
+type PlayerReadyData struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+}
+
+type AnswerProgressData struct {
+	Index    int `json:"index"`
+	Answered int `json:"answered"`
+	Total    int `json:"total"`
+}
+
+type AnswerAcceptedData struct {
+	Index  int       `json:"index"`
+	Answer string    `json:"answer"`
+	Time   time.Time `json:"time"`
+}
+
+const (
+	RejectNotPlaying = "not_playing"
+	RejectLate       = "late"
+	RejectDuplicate  = "duplicate"
+	RejectInvalid    = "invalid"
+)
+
+type AnswerRejectedData struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,109 @@
+// Package protocol defines the messages exchanged over the websocket of a live game.
+//
+// Every message is a JSON object with a numeric "type" and an optional "data" payload. The numbers
+// of the message types are part of the protocol and never change; new types get new numbers.
+// Clients negotiate the version by requesting the websocket subprotocol "kakadoo.v<version>".
+// Clients that request no subprotocol get version 1.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is the latest version of the protocol.
+const Version = 1
+
+const subprotocolPrefix = "kakadoo.v"
+
+// Versions are the versions of the protocol the server speaks, latest first.
+var Versions = []int{Version}
+
+// Subprotocol returns the websocket subprotocol of the version.
+func Subprotocol(version int) string {
+	return subprotocolPrefix + strconv.Itoa(version)
+}
+
+// Subprotocols returns the websocket subprotocols of all versions, latest first.
+func Subprotocols() []string {
+	subprotocols := make([]string, len(Versions))
+	for i, version := range Versions {
+		subprotocols[i] = Subprotocol(version)
+	}
+	return subprotocols
+}
+
+// ParseSubprotocol returns the version of the websocket subprotocol. The empty subprotocol stands
+// for version 1, as spoken by clients that do not negotiate.
+func ParseSubprotocol(subprotocol string) (int, error) {
+	if subprotocol == "" {
+		return 1, nil
+	}
+	if !strings.HasPrefix(subprotocol, subprotocolPrefix) {
+		return 0, fmt.Errorf("unknown subprotocol %q", subprotocol)
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(subprotocol, subprotocolPrefix))
+	if err != nil {
+		return 0, fmt.Errorf("unknown subprotocol %q", subprotocol)
+	}
+	for _, v := range Versions {
+		if v == version {
+			return version, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported protocol version %d", version)
+}
+
+type MessageType int
+
+const (
+	Ready              MessageType = 0
+	PlayerRegistered   MessageType = 1
+	PlayerUnregistered MessageType = 2
+	GameStarted        MessageType = 3
+	NextQuestion       MessageType = 4
+	Task               MessageType = 5
+	Timer              MessageType = 6
+	Answer             MessageType = 7
+	TaskFinished       MessageType = 8
+	GameFinished       MessageType = 9
+	PowerUp            MessageType = 10
+	PlayerReady        MessageType = 11
+	AnswerProgress     MessageType = 12
+	AnswerAccepted     MessageType = 13
+	AnswerRejected     MessageType = 14
+
+	NotReady       MessageType = -1
+	PlayerExists   MessageType = -2
+	GameFull       MessageType = -3
+	GameInProgress MessageType = -4
+)
+
+func (t MessageType) String() string {
+	if spec := Lookup(t); spec != nil {
+		return spec.Name
+	}
+	return "unknown(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Message is a message as sent, with one of the payloads of this package as Data.
+type Message struct {
+	Type MessageType `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// RawMessage is a message as received, with the payload left to decode by its type.
+type RawMessage struct {
+	Type MessageType     `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Decode decodes the payload of the message into v. A null payload counts as none.
+func (m *RawMessage) Decode(v interface{}) error {
+	if len(m.Data) == 0 || string(m.Data) == "null" {
+		return fmt.Errorf("%v: no data", m.Type)
+	}
+	return json.Unmarshal(m.Data, v)
+}
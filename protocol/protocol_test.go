@@ -0,0 +1,176 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseSubprotocol(t *testing.T) {
+	tests := []struct {
+		subprotocol string
+		version     int
+		err         string
+	}{
+		{"", 1, ""},
+		{Subprotocol(Version), Version, ""},
+		{"kakadoo.v1", 1, ""},
+		{"kakadoo.v99", 0, "unsupported protocol version 99"},
+		{"kakadoo.v", 0, `unknown subprotocol "kakadoo.v"`},
+		{"kakadoo.vx", 0, `unknown subprotocol "kakadoo.vx"`},
+		{"kakadoo.v-1", 0, "unsupported protocol version -1"},
+		{"graphql-ws", 0, `unknown subprotocol "graphql-ws"`},
+	}
+	for _, tt := range tests {
+		version, err := ParseSubprotocol(tt.subprotocol)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: got error %v, want %q", tt.subprotocol, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || version != tt.version {
+			t.Errorf("%q: got %d, %v, want %d", tt.subprotocol, version, err, tt.version)
+		}
+	}
+
+	for _, subprotocol := range Subprotocols() {
+		if _, err := ParseSubprotocol(subprotocol); err != nil {
+			t.Errorf("%q: %v", subprotocol, err)
+		}
+	}
+}
+
+func TestRawMessageDecode(t *testing.T) {
+	tests := []struct {
+		message string
+		into    interface{}
+		err     bool
+	}{
+		{`{"type": 7, "data": "4"}`, new(string), false},
+		{`{"type": 11, "data": true}`, new(bool), false},
+		{`{"type": 7}`, new(string), true},
+		{`{"type": 7, "data": null}`, new(string), true},
+		{`{"type": 7, "data": 4}`, new(string), true},
+		{`{"type": 7, "data": ["4"]}`, new(string), true},
+		{`{"type": 11, "data": "yes"}`, new(bool), true},
+		{`{"type": 11, "data": {"name": "alice"}}`, new(bool), true},
+	}
+	for _, tt := range tests {
+		var m RawMessage
+		if err := json.Unmarshal([]byte(tt.message), &m); err != nil {
+			t.Errorf("%s: %v", tt.message, err)
+			continue
+		}
+		if err := m.Decode(tt.into); (err != nil) != tt.err {
+			t.Errorf("%s: got error %v, want error %v", tt.message, err, tt.err)
+		}
+	}
+
+	for _, message := range []string{`{"type": "answer"}`, `{"type": 7, "data": "4"`, `[7, "4"]`} {
+		var m RawMessage
+		if err := json.Unmarshal([]byte(message), &m); err == nil {
+			t.Errorf("%s: got message %+v, want error", message, m)
+		}
+	}
+}
+
+func TestMessages(t *testing.T) {
+	types := make(map[MessageType]bool)
+	names := make(map[string]bool)
+	for _, spec := range Messages {
+		if types[spec.Type] || names[spec.Name] {
+			t.Errorf("%s: type %d or name given twice", spec.Name, spec.Type)
+		}
+		types[spec.Type], names[spec.Name] = true, true
+		if spec.Type.String() != spec.Name {
+			t.Errorf("%d: got name %q, want %q", spec.Type, spec.Type.String(), spec.Name)
+		}
+	}
+	if got := MessageType(99).String(); got != "unknown(99)" {
+		t.Errorf("got name %q of an unknown type", got)
+	}
+}
+
+type testSchema struct {
+	OneOf []struct {
+		Title      string                     `json:"title"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	} `json:"oneOf"`
+	Definitions map[string]struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+		Required   []string                   `json:"required"`
+	} `json:"definitions"`
+}
+
+func TestSchemaMatchesPayloads(t *testing.T) {
+	b, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema testSchema
+	if err = json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.OneOf) != len(Messages) {
+		t.Fatalf("got %d messages in the schema, want %d", len(schema.OneOf), len(Messages))
+	}
+
+	for i, spec := range Messages {
+		message := schema.OneOf[i]
+		if message.Title != spec.Name {
+			t.Errorf("message %d: got title %q, want %q", i, message.Title, spec.Name)
+		}
+		if _, ok := message.Properties["data"]; ok != (spec.Data != nil) {
+			t.Errorf("%s: got data in the schema %v, want %v", spec.Name, ok, spec.Data != nil)
+		}
+		if spec.Data == nil || reflect.TypeOf(spec.Data).Kind() != reflect.Struct {
+			continue
+		}
+
+		// every field of the payload is a property of its definition, the ones sent always required
+		typ := reflect.TypeOf(spec.Data)
+		definition, ok := schema.Definitions[typ.Name()]
+		if !ok {
+			t.Errorf("%s: no definition of %s", spec.Name, typ.Name())
+			continue
+		}
+		sent, err := json.Marshal(spec.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]interface{}
+		if err = json.Unmarshal(sent, &fields); err != nil {
+			t.Fatal(err)
+		}
+		for name := range fields {
+			if _, ok := definition.Properties[name]; !ok {
+				t.Errorf("%s: field %q is missing from the schema", typ.Name(), name)
+			}
+		}
+		required := make([]string, 0, len(fields))
+		for name := range fields {
+			required = append(required, name)
+		}
+		sort.Strings(required)
+		sort.Strings(definition.Required)
+		if !reflect.DeepEqual(required, definition.Required) {
+			t.Errorf("%s: got required %q, want %q", typ.Name(), definition.Required, required)
+		}
+		if len(definition.Properties) != jsonFields(typ) {
+			t.Errorf("%s: got %d properties, want %d", typ.Name(), len(definition.Properties), jsonFields(typ))
+		}
+	}
+}
+
+// jsonFields counts the fields of the struct that are encoded to JSON.
+func jsonFields(typ reflect.Type) int {
+	n := 0
+	for i := 0; i < typ.NumField(); i++ {
+		if field := typ.Field(i); field.PkgPath == "" && field.Tag.Get("json") != "-" {
+			n++
+		}
+	}
+	return n
+}
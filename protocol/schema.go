@@ -0,0 +1,164 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type Direction string
+
+const (
+	ServerToClient Direction = "server"
+	ClientToServer Direction = "client"
+	Both           Direction = "both"
+)
+
+// MessageSpec describes a message type. Data is a zero value of the payload, or nil if the message
+// carries none.
+type MessageSpec struct {
+	Type        MessageType
+	Name        string
+	Direction   Direction
+	Description string
+	Data        interface{}
+}
+
+var Messages = []MessageSpec{
+	{Ready, "ready", ServerToClient, "The player has joined the game.", ReadyData{}},
+	{PlayerRegistered, "player_registered", ServerToClient, "Another player has joined the game.", Player{}},
+	{PlayerUnregistered, "player_unregistered", ServerToClient, "Another player has left the game.", Player{}},
+	{GameStarted, "game_started", Both, "The author starts the game; data is only sent by the server.", GameStartedData{}},
+	{NextQuestion, "next_question", ClientToServer, "The author starts the next task.", nil},
+	{Task, "task", ServerToClient, "A task has started.", TaskData{}},
+	{Timer, "timer", ServerToClient, "Seconds left to answer the task.", 0},
+	{Answer, "answer", ClientToServer, "The player answers the task.", ""},
+	{TaskFinished, "task_finished", ServerToClient, "The task has ended.", TaskFinishedData{}},
+	{GameFinished, "game_finished", Both, "The author finishes the game; data is only sent by the server.", GameFinishedData{}},
+	{PowerUp, "power_up", Both, "The player arms a power-up for the next task; the server confirms it.", ""},
+	{PlayerReady, "player_ready", Both, "The player is ready or not; the server sends PlayerReadyData to all.", PlayerReadyData{}},
+	{AnswerProgress, "answer_progress", ServerToClient, "How many players have answered, sent to the author and spectators.", AnswerProgressData{}},
+	{AnswerAccepted, "answer_accepted", ServerToClient, "The answer of the player was recorded.", AnswerAcceptedData{}},
+	{AnswerRejected, "answer_rejected", ServerToClient, "The answer of the player was not recorded.", AnswerRejectedData{}},
+	{NotReady, "not_ready", ServerToClient, "The game is not open yet, the player waits in the lobby.", nil},
+	{PlayerExists, "player_exists", ServerToClient, "The name is taken, the connection is closed.", nil},
+	{GameFull, "game_full", ServerToClient, "The game is full, the connection is closed.", nil},
+	{GameInProgress, "game_in_progress", ServerToClient, "The game has started and does not let players join late.", nil},
+}
+
+// Lookup returns the spec of the message type, or nil if there is none.
+func Lookup(t MessageType) *MessageSpec {
+	for i := range Messages {
+		if Messages[i].Type == t {
+			return &Messages[i]
+		}
+	}
+	return nil
+}
+
+// Schema returns the JSON Schema of the messages of the protocol. Payloads sent by clients differ
+// from those sent by the server for some messages, so the payload of those is described as either.
+func Schema() ([]byte, error) {
+	g := &schemaGenerator{definitions: make(map[string]interface{})}
+
+	messages := make([]interface{}, len(Messages))
+	for i, spec := range Messages {
+		properties := map[string]interface{}{
+			"type": map[string]interface{}{"const": int(spec.Type)},
+		}
+		required := []string{"type"}
+		switch {
+		case spec.Data == nil:
+		case spec.Type == PlayerReady:
+			properties["data"] = map[string]interface{}{
+				"oneOf": []interface{}{map[string]interface{}{"type": "boolean"}, g.schema(reflect.TypeOf(spec.Data))},
+			}
+		default:
+			properties["data"] = g.schema(reflect.TypeOf(spec.Data))
+		}
+		if spec.Data != nil && spec.Direction != Both {
+			required = append(required, "data")
+		}
+		messages[i] = map[string]interface{}{
+			"title":                spec.Name,
+			"description":          spec.Description,
+			"x-direction":          spec.Direction,
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"$id":         "https://kakadoo.herokuapp.com/protocol/" + Subprotocol(Version) + ".json",
+		"title":       "Kakadoo wire protocol, version " + Subprotocol(Version),
+		"oneOf":       messages,
+		"definitions": g.definitions,
+	}, "", "  ")
+}
+
+type schemaGenerator struct {
+	definitions map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) schema(t reflect.Type) interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		return map[string]interface{}{"oneOf": []interface{}{g.schema(t.Elem()), map[string]interface{}{"type": "null"}}}
+	case t.Kind() == reflect.Struct:
+		if _, ok := g.definitions[t.Name()]; !ok {
+			g.definitions[t.Name()] = nil
+			g.definitions[t.Name()] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": []string{"object", "null"}, "additionalProperties": g.schema(t.Elem())}
+	}
+	return map[string]interface{}{}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "-" || field.PkgPath != "" {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+		if len(tag) < 2 || tag[1] != "omitempty" {
+			required = append(required, name)
+		}
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}